@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/mirkobrombin/go-foundation/pkg/cpio"
+)
+
+// errDiffer is returned by diff when the archives differ.
+var errDiffer = errors.New("archives differ")
+
+// summary is what diff compares for each entry.
+type summary struct {
+	mode     uint32
+	uid, gid uint32
+	size     uint32
+	rdev     [2]uint32
+	link     string
+	sum      [sha256.Size]byte
+}
+
+func summarize(name string) (map[string]summary, error) {
+	entries := map[string]summary{}
+	groups := map[cpio.LinkKey][]string{}
+	err := walk(name, func(e *cpio.Entry, rd *cpio.Reader) error {
+		h := sha256.New()
+		if _, err := io.Copy(h, rd); err != nil {
+			return err
+		}
+		s := summary{
+			mode: e.Mode,
+			uid:  e.UID,
+			gid:  e.GID,
+			size: e.FileSize,
+			rdev: [2]uint32{e.RDevMajor, e.RDevMinor},
+			link: e.Linkname,
+		}
+		h.Sum(s.sum[:0])
+		entries[e.Name] = s
+		if e.IsRegular() && e.NLink > 1 {
+			key := e.LinkKey()
+			groups[key] = append(groups[key], e.Name)
+		}
+		return nil
+	})
+	// A hard link group carries its data on any one of its links: give
+	// every link the size and content of that one.
+	for _, names := range groups {
+		src := entries[names[0]]
+		for _, name := range names {
+			if entries[name].size > 0 {
+				src = entries[name]
+				break
+			}
+		}
+		for _, name := range names {
+			s := entries[name]
+			s.size, s.sum = src.size, src.sum
+			entries[name] = s
+		}
+	}
+	return entries, err
+}
+
+func diff(args []string, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("diff", flag.ContinueOnError)
+	if err := parse(fset, args, stderr, 2); err != nil {
+		return err
+	}
+	a, err := summarize(fset.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := summarize(fset.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	differ := false
+	for _, name := range names {
+		sa, inA := a[name]
+		sb, inB := b[name]
+		var changes []string
+		switch {
+		case !inA:
+			fmt.Fprintf(stdout, "+ %s\n", name)
+		case !inB:
+			fmt.Fprintf(stdout, "- %s\n", name)
+		default:
+			changes = compare(sa, sb)
+			if len(changes) == 0 {
+				continue
+			}
+			fmt.Fprintf(stdout, "~ %s:", name)
+			for _, c := range changes {
+				fmt.Fprintf(stdout, " %s", c)
+			}
+			fmt.Fprintln(stdout)
+		}
+		differ = true
+	}
+	if differ {
+		return errDiffer
+	}
+	return nil
+}
+
+// compare describes the differences between two versions of an entry.
+func compare(a, b summary) []string {
+	var changes []string
+	if a.mode != b.mode {
+		changes = append(changes, fmt.Sprintf("mode %s -> %s", modeString(a.mode), modeString(b.mode)))
+	}
+	if a.uid != b.uid || a.gid != b.gid {
+		changes = append(changes, fmt.Sprintf("owner %d:%d -> %d:%d", a.uid, a.gid, b.uid, b.gid))
+	}
+	if a.rdev != b.rdev {
+		changes = append(changes, fmt.Sprintf("device %d,%d -> %d,%d", a.rdev[0], a.rdev[1], b.rdev[0], b.rdev[1]))
+	}
+	switch {
+	case a.link != b.link:
+		changes = append(changes, fmt.Sprintf("target %s -> %s", a.link, b.link))
+	case a.size != b.size:
+		changes = append(changes, fmt.Sprintf("size %d -> %d", a.size, b.size))
+	case a.sum != b.sum:
+		changes = append(changes, "content")
+	}
+	return changes
+}
@@ -0,0 +1,387 @@
+// Command gocpio lists, extracts, creates, compares and verifies cpio
+// archives, and computes their manifests, with the
+// github.com/mirkobrombin/go-foundation/pkg/cpio package.
+//
+// Usage:
+//
+//	gocpio list [-n] archive
+//	gocpio extract [-C dir] [-p] [-overwrite replace|skip|fail] archive
+//	gocpio create [-o file] [-spec file] [-format newc|crc|odc] [-gzip]
+//		[-reproducible] [dir]
+//	gocpio diff a b
+//	gocpio verify [-manifest file] archive|dir
+//	gocpio manifest archive
+//
+// Compressed archives are detected automatically. An archive named "-" is
+// read from standard input.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mirkobrombin/go-foundation/pkg/cpio"
+)
+
+const usage = `usage:
+	gocpio list [-n] archive
+	gocpio extract [-C dir] [-p] [-overwrite replace|skip|fail] archive
+	gocpio create [-o file] [-spec file] [-format newc|crc|odc] [-gzip]
+		[-reproducible] [dir]
+	gocpio diff a b
+	gocpio verify [-manifest file] archive|dir
+	gocpio manifest archive
+`
+
+// errUsage reports a command line error; the usage has been printed.
+var errUsage = errors.New("usage")
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case err == nil:
+	case errors.Is(err, errDiffer):
+		os.Exit(1)
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "gocpio:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return errUsage
+	}
+	cmds := map[string]func([]string, io.Writer, io.Writer) error{
+		"list":     list,
+		"extract":  extract,
+		"create":   create,
+		"diff":     diff,
+		"verify":   verify,
+		"manifest": manifest,
+	}
+	cmd, ok := cmds[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "gocpio: unknown command %q\n%s", args[0], usage)
+		return errUsage
+	}
+	return cmd(args[1:], stdout, stderr)
+}
+
+// parse parses the flags of a subcommand and checks its argument count.
+func parse(fset *flag.FlagSet, args []string, stderr io.Writer, nargs ...int) error {
+	fset.SetOutput(stderr)
+	fset.Usage = func() { fmt.Fprint(stderr, usage) }
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	for _, n := range nargs {
+		if fset.NArg() == n {
+			return nil
+		}
+	}
+	fset.Usage()
+	return errUsage
+}
+
+// openArchive opens the named archive, or standard input for "-".
+func openArchive(name string, opts ...cpio.ReaderOption) (*cpio.Reader, error) {
+	opts = append(opts, cpio.WithDecompression(), cpio.WithConcatenated())
+	if name == "-" {
+		return cpio.NewReader(os.Stdin, opts...), nil
+	}
+	return cpio.Open(name, opts...)
+}
+
+// walk calls fn for every entry of the named archive. The body of each
+// entry can be read from rd.
+func walk(name string, fn func(e *cpio.Entry, rd *cpio.Reader) error, opts ...cpio.ReaderOption) error {
+	rd, err := openArchive(name, opts...)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	for {
+		e, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(e, rd); err != nil {
+			return err
+		}
+	}
+}
+
+func list(args []string, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("list", flag.ContinueOnError)
+	numeric := fset.Bool("n", false, "print inode numbers")
+	if err := parse(fset, args, stderr, 1); err != nil {
+		return err
+	}
+	return walk(fset.Arg(0), func(e *cpio.Entry, _ *cpio.Reader) error {
+		if *numeric {
+			fmt.Fprintf(stdout, "%8d ", e.Ino)
+		}
+		size := fmt.Sprint(e.FileSize)
+		if m := e.FileMode(); m&fs.ModeDevice != 0 {
+			size = fmt.Sprintf("%d, %d", e.RDevMajor, e.RDevMinor)
+		}
+		mtime := time.Unix(int64(e.MTime), 0).UTC().Format("2006-01-02 15:04")
+		fmt.Fprintf(stdout, "%s %3d %-5d %-5d %10s %s %s", modeString(e.Mode), e.NLink, e.UID, e.GID, size, mtime, e.Name)
+		if e.IsSymlink() {
+			fmt.Fprintf(stdout, " -> %s", e.Linkname)
+		}
+		fmt.Fprintln(stdout)
+		return nil
+	})
+}
+
+// modeString formats a cpio mode as ls -l and cpio -tv do.
+func modeString(mode uint32) string {
+	b := []byte("?---------")
+	switch mode & 0170000 {
+	case 0140000:
+		b[0] = 's'
+	case 0120000:
+		b[0] = 'l'
+	case 0100000:
+		b[0] = '-'
+	case 0060000:
+		b[0] = 'b'
+	case 0040000:
+		b[0] = 'd'
+	case 0020000:
+		b[0] = 'c'
+	case 0010000:
+		b[0] = 'p'
+	}
+	const rwx = "rwxrwxrwx"
+	for i := range 9 {
+		if mode&(0400>>i) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+	special := func(i int, bit uint32, set, unset byte) {
+		if mode&bit == 0 {
+			return
+		}
+		if b[i] == 'x' {
+			b[i] = set
+		} else {
+			b[i] = unset
+		}
+	}
+	special(3, 04000, 's', 'S')
+	special(6, 02000, 's', 'S')
+	special(9, 01000, 't', 'T')
+	return string(b)
+}
+
+func extract(args []string, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("extract", flag.ContinueOnError)
+	dir := fset.String("C", ".", "extract into `dir`")
+	preserve := fset.Bool("p", false, "preserve modes, mtimes and, as root, ownership")
+	overwrite := fset.String("overwrite", "replace", "existing files: replace, skip or fail")
+	if err := parse(fset, args, stderr, 1); err != nil {
+		return err
+	}
+	uo := cpio.UnpackOptions{PreserveOwner: *preserve, PreserveMode: *preserve, PreserveMTime: *preserve}
+	switch *overwrite {
+	case "replace":
+		uo.Overwrite = cpio.OverwriteReplace
+	case "skip":
+		uo.Overwrite = cpio.OverwriteSkip
+	case "fail":
+		uo.Overwrite = cpio.OverwriteFail
+	default:
+		return fmt.Errorf("invalid -overwrite %q", *overwrite)
+	}
+
+	var r io.Reader = os.Stdin
+	if name := fset.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	return cpio.Unpack(r, *dir, uo, cpio.WithDecompression(), cpio.WithConcatenated())
+}
+
+func create(args []string, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("create", flag.ContinueOnError)
+	output := fset.String("o", "-", "write the archive to `file`")
+	spec := fset.String("spec", "", "build from a gen_init_cpio description `file`")
+	format := fset.String("format", "newc", "archive format: newc, crc or odc")
+	gzip := fset.Bool("gzip", false, "compress with gzip")
+	reproducible := fset.Bool("reproducible", false, "clamp mtimes to SOURCE_DATE_EPOCH, renumber inodes and set owner 0:0")
+	if err := parse(fset, args, stderr, 0, 1); err != nil {
+		return err
+	}
+	if *spec == "" && fset.NArg() == 0 {
+		fset.Usage()
+		return errUsage
+	}
+
+	var opts []cpio.WriterOption
+	switch *format {
+	case "newc":
+	case "crc":
+		opts = append(opts, cpio.WithCRC())
+	case "odc":
+		opts = append(opts, cpio.WithODC())
+	default:
+		return fmt.Errorf("invalid -format %q", *format)
+	}
+	if *gzip {
+		opts = append(opts, cpio.WithGzip())
+	}
+	if *reproducible {
+		opts = append(opts, cpio.WithReproducible(0, 0))
+	}
+
+	w := stdout
+	var out *os.File
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w, out = f, f
+	}
+
+	var err error
+	if *spec != "" {
+		err = createFromSpec(*spec, fset.Arg(0), w, opts)
+	} else {
+		root := fset.Arg(0)
+		err = cpio.PackFS(dirFS{os.DirFS(root), root}, w, opts...)
+	}
+	if err != nil || out == nil {
+		return err
+	}
+	return out.Close()
+}
+
+// createFromSpec packs a spec file. File locations are relative to src,
+// or to the current directory when src is empty.
+func createFromSpec(spec, src string, w io.Writer, opts []cpio.WriterOption) error {
+	f, err := os.Open(spec)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var fsys fs.FS
+	if src != "" {
+		fsys = os.DirFS(src)
+	}
+	return cpio.PackSpec(f, fsys, w, opts...)
+}
+
+// dirFS adds symbolic link support to os.DirFS, so that PackFS stores
+// links as links.
+type dirFS struct {
+	fs.FS
+	root string
+}
+
+func (d dirFS) ReadLink(name string) (string, error) {
+	return os.Readlink(filepath.Join(d.root, filepath.FromSlash(name)))
+}
+
+func verify(args []string, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("verify", flag.ContinueOnError)
+	manifestFile := fset.String("manifest", "", "check against the JSON manifest in `file`")
+	if err := parse(fset, args, stderr, 1); err != nil {
+		return err
+	}
+	if *manifestFile != "" {
+		return verifyManifest(*manifestFile, fset.Arg(0), stdout)
+	}
+	n := 0
+	err := walk(fset.Arg(0), func(e *cpio.Entry, rd *cpio.Reader) error {
+		n++
+		_, err := io.Copy(io.Discard, rd)
+		return err
+	}, cpio.WithVerifyCRC())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: %d entries ok\n", fset.Arg(0), n)
+	return nil
+}
+
+// verifyManifest checks an archive, or a directory extracted from one,
+// against the JSON manifest in file.
+func verifyManifest(file, name string, stdout io.Writer) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	m, err := cpio.ReadManifest(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if fi, serr := os.Stat(name); serr == nil && fi.IsDir() {
+		err = m.VerifyDir(name, cpio.ManifestAll)
+	} else {
+		err = withArchive(name, func(r io.Reader) error {
+			return m.VerifyArchive(r, cpio.ManifestAll,
+				cpio.WithDecompression(), cpio.WithConcatenated(), cpio.WithVerifyCRC())
+		})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: %d entries match %s\n", name, len(m.Entries), file)
+	return nil
+}
+
+func manifest(args []string, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("manifest", flag.ContinueOnError)
+	if err := parse(fset, args, stderr, 1); err != nil {
+		return err
+	}
+	return withArchive(fset.Arg(0), func(r io.Reader) error {
+		m, err := cpio.NewManifest(r,
+			cpio.WithDecompression(), cpio.WithConcatenated())
+		if err != nil {
+			return err
+		}
+		data, err := m.MarshalIndent()
+		if err != nil {
+			return err
+		}
+		_, err = stdout.Write(data)
+		return err
+	})
+}
+
+// withArchive calls fn with the raw stream of the named archive, or
+// standard input for "-".
+func withArchive(name string, fn func(r io.Reader) error) error {
+	if name == "-" {
+		return fn(os.Stdin)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(f)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mirkobrombin/go-foundation/pkg/cpio"
+)
+
+func runOK(t *testing.T, args ...string) string {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	if err := run(args, &stdout, &stderr); err != nil {
+		t.Fatalf("%v: %v\n%s", args, err, stderr.String())
+	}
+	return stdout.String()
+}
+
+func writeFile(t *testing.T, path, data string, mode os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "root")
+	writeFile(t, filepath.Join(root, "bin", "busybox"), "elf", 0755)
+	writeFile(t, filepath.Join(root, "etc", "hostname"), "box\n", 0644)
+	if err := os.Symlink("busybox", filepath.Join(root, "bin", "sh")); err != nil {
+		t.Fatal(err)
+	}
+
+	a := filepath.Join(tmp, "a.cpio.gz")
+	runOK(t, "create", "-gzip", "-format", "crc", "-o", a, root)
+
+	out := runOK(t, "list", a)
+	for _, want := range []string{"bin/sh -> busybox", "-rwxr-xr-x", "etc/hostname"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("list: missing %q in\n%s", want, out)
+		}
+	}
+	if out := runOK(t, "verify", a); !strings.Contains(out, "5 entries ok") {
+		t.Errorf("verify: got %q", out)
+	}
+
+	dst := filepath.Join(tmp, "out")
+	runOK(t, "extract", "-C", dst, "-p", a)
+	if target, err := os.Readlink(filepath.Join(dst, "bin", "sh")); err != nil || target != "busybox" {
+		t.Errorf("extract: got %q, %v", target, err)
+	}
+	if out := runOK(t, "diff", a, a); out != "" {
+		t.Errorf("diff: got %q", out)
+	}
+	mf := filepath.Join(tmp, "manifest.json")
+	if err := os.WriteFile(mf, []byte(runOK(t, "manifest", a)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{a, dst} {
+		if out := runOK(t, "verify", "-manifest", mf, name); !strings.Contains(out, "5 entries match") {
+			t.Errorf("verify -manifest %s: got %q", name, out)
+		}
+	}
+
+	writeFile(t, filepath.Join(root, "etc", "hostname"), "other\n", 0600)
+	writeFile(t, filepath.Join(root, "init"), "#!/bin/sh\n", 0755)
+	if err := os.Remove(filepath.Join(root, "bin", "sh")); err != nil {
+		t.Fatal(err)
+	}
+	b := filepath.Join(tmp, "b.cpio")
+	runOK(t, "create", "-o", b, root)
+
+	var stdout bytes.Buffer
+	if err := run([]string{"verify", "-manifest", mf, b}, &stdout, &stdout); !errors.Is(err, cpio.ErrManifestMismatch) {
+		t.Fatalf("verify -manifest: got %v", err)
+	}
+	if err := run([]string{"diff", a, b}, &stdout, &stdout); !errors.Is(err, errDiffer) {
+		t.Fatalf("diff: got %v", err)
+	}
+	want := "- bin/sh\n~ etc/hostname: mode -rw-r--r-- -> -rw------- size 4 -> 6\n+ init\n"
+	if stdout.String() != want {
+		t.Errorf("diff: got\n%s\nwant\n%s", stdout.String(), want)
+	}
+}
+
+func TestDiffHardlinks(t *testing.T) {
+	tmp := t.TempDir()
+	archive := func(name string, bodies ...string) string {
+		t.Helper()
+		var buf bytes.Buffer
+		wr := cpio.NewWriter(&buf)
+		for i, body := range bodies {
+			e := &cpio.Entry{Name: string(rune('a' + i)), Ino: 7, Mode: 0100644, NLink: 2, FileSize: uint32(len(body))}
+			if err := wr.WriteEntry(e, strings.NewReader(body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := wr.Close(); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(tmp, name)
+		writeFile(t, path, buf.String(), 0644)
+		return path
+	}
+	first, last := archive("first.cpio", "data", ""), archive("last.cpio", "", "data")
+	if out := runOK(t, "diff", first, last); out != "" {
+		t.Errorf("diff: got %q", out)
+	}
+
+	var stdout bytes.Buffer
+	changed := archive("changed.cpio", "", "more data")
+	if err := run([]string{"diff", first, changed}, &stdout, &stdout); !errors.Is(err, errDiffer) {
+		t.Fatalf("diff: got %v", err)
+	}
+	want := "~ a: size 4 -> 9\n~ b: size 4 -> 9\n"
+	if stdout.String() != want {
+		t.Errorf("diff: got\n%s\nwant\n%s", stdout.String(), want)
+	}
+}
+
+func TestCreateSpec(t *testing.T) {
+	tmp := t.TempDir()
+	writeFile(t, filepath.Join(tmp, "src", "init"), "#!/bin/sh\n", 0644)
+	spec := filepath.Join(tmp, "list")
+	writeFile(t, spec, "dir /dev 0755 0 0\nnod /dev/console 0600 0 0 c 5 1\nfile /init init 0755 0 0\n", 0644)
+
+	archive := filepath.Join(tmp, "initrd.cpio")
+	runOK(t, "create", "-spec", spec, "-o", archive, filepath.Join(tmp, "src"))
+	out := runOK(t, "list", archive)
+	if !strings.Contains(out, "\ncrw------- ") || !strings.Contains(out, "5, 1") {
+		t.Errorf("list: got\n%s", out)
+	}
+}
+
+func TestModeString(t *testing.T) {
+	for mode, want := range map[uint32]string{
+		0100644: "-rw-r--r--",
+		0104755: "-rwsr-xr-x",
+		0102644: "-rw-r-Sr--",
+		0041777: "drwxrwxrwt",
+		0041700: "drwx-----T",
+		0120777: "lrwxrwxrwx",
+		0020620: "crw--w----",
+		0060660: "brw-rw----",
+		0010600: "prw-------",
+		0140755: "srwxr-xr-x",
+	} {
+		if got := modeString(mode); got != want {
+			t.Errorf("%o: got %s, want %s", mode, got, want)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"bogus"}, {"list"}, {"diff", "a"}, {"create"}} {
+		var stderr bytes.Buffer
+		if err := run(args, &stderr, &stderr); !errors.Is(err, errUsage) {
+			t.Errorf("%v: got %v", args, err)
+		}
+	}
+}
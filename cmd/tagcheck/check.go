@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mirkobrombin/go-foundation/pkg/tags"
+)
+
+// diagnostic is one finding, printed as "file:line:col: msg".
+type diagnostic struct {
+	pos token.Position
+	msg string
+}
+
+func (d diagnostic) String() string { return fmt.Sprintf("%s: %s", d.pos, d.msg) }
+
+type checker struct {
+	schema *schema
+	parser *tags.Parser
+	tests  bool
+	fset   *token.FileSet
+	diags  []diagnostic
+}
+
+// expand turns the command line patterns into directories. A pattern
+// ending in "/..." matches the directory and every directory below it,
+// except testdata, vendor and those starting with "." or "_".
+func expand(patterns []string) ([]string, error) {
+	var dirs []string
+	for _, pattern := range patterns {
+		root, ok := strings.CutSuffix(pattern, "/...")
+		if !ok {
+			if pattern == "..." {
+				root = "."
+			} else {
+				dirs = append(dirs, pattern)
+				continue
+			}
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			name := d.Name()
+			if path != root && (name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dirs, nil
+}
+
+// checkDir checks the package in dir and, with tests, its test files.
+func (c *checker) checkDir(dir string) error {
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		var noGo *build.NoGoError
+		if errors.As(err, &noGo) {
+			return nil
+		}
+		return err
+	}
+	files := bp.GoFiles
+	if c.tests {
+		files = append(files, bp.TestGoFiles...)
+	}
+	if err := c.checkFiles(dir, files); err != nil {
+		return err
+	}
+	if c.tests && len(bp.XTestGoFiles) > 0 {
+		return c.checkFiles(dir, bp.XTestGoFiles)
+	}
+	return nil
+}
+
+// checkFiles parses and type-checks the files of one package, then checks
+// every struct type in them. Type errors, such as unresolved imports, are
+// ignored: struct tags do not depend on them.
+func (c *checker) checkFiles(dir string, names []string) error {
+	var files []*ast.File
+	for _, name := range names {
+		f, err := parser.ParseFile(c.fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	info := &types.Info{Types: map[ast.Expr]types.TypeAndValue{}}
+	conf := types.Config{Importer: importer.Default(), Error: func(error) {}}
+	conf.Check(files[0].Name.Name, c.fset, files, info)
+
+	for _, f := range files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.TypeSpec:
+				// Structs nested in a declaration are reported under its name.
+				ast.Inspect(n.Type, func(m ast.Node) bool {
+					if st, ok := m.(*ast.StructType); ok {
+						c.checkStruct(n.Name.Name, st, info)
+					}
+					return true
+				})
+				return false
+			case *ast.StructType:
+				c.checkStruct("struct", n, info)
+			}
+			return true
+		})
+	}
+	return nil
+}
+
+// checkStruct checks the tags of the fields of st, declared in the type
+// named owner. Names and tags come from the type checker when it could
+// type the struct, from the syntax otherwise.
+func (c *checker) checkStruct(owner string, st *ast.StructType, info *types.Info) {
+	typ, _ := info.TypeOf(st).(*types.Struct)
+	i := 0
+	for _, field := range st.Fields.List {
+		n := max(len(field.Names), 1)
+		for j := 0; j < n; j++ {
+			name, tag := fieldName(field, j), ""
+			if field.Tag != nil {
+				tag, _ = strconv.Unquote(field.Tag.Value)
+			}
+			if typ != nil && i < typ.NumFields() {
+				name, tag = typ.Field(i).Name(), typ.Tag(i)
+			}
+			i++
+			if field.Tag != nil {
+				c.checkTag(owner+"."+name, tag, field.Tag)
+			}
+		}
+	}
+}
+
+func fieldName(field *ast.Field, j int) string {
+	if len(field.Names) > 0 {
+		return field.Names[j].Name
+	}
+	typ := field.Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if sel, ok := typ.(*ast.SelectorExpr); ok {
+		return sel.Sel.Name
+	}
+	if id, ok := typ.(*ast.Ident); ok {
+		return id.Name
+	}
+	return "?"
+}
+
+// checkTag checks the struct tag tag of the field named name, whose
+// literal is lit.
+func (c *checker) checkTag(name, tag string, lit *ast.BasicLit) {
+	var present []string
+	for _, spelling := range append([]string{c.schema.Tag}, c.schema.Aliases...) {
+		if _, ok := reflect.StructTag(tag).Lookup(spelling); ok {
+			present = append(present, spelling)
+		}
+	}
+	if len(present) == 0 {
+		return
+	}
+	source := present[0]
+	for _, other := range present[1:] {
+		c.report(lit, -1, "%s: %s tag conflicts with %s tag", name, other, source)
+	}
+
+	value := reflect.StructTag(tag).Get(source)
+	start := valueOffset(tag, source)
+	m, err := c.parser.ParseStrict(value)
+	if err != nil {
+		offset, msg := describe(err)
+		switch {
+		case start < 0:
+			offset = -1
+		case strings.Contains(value, `\`):
+			offset = start // escapes shift columns in the literal
+		default:
+			offset += start
+		}
+		c.report(lit, offset, "%s: %s tag: %s", name, source, msg)
+		return
+	}
+	for _, msg := range c.schema.checkArity(m) {
+		c.report(lit, start, "%s: %s tag: %s", name, source, msg)
+	}
+}
+
+// describe returns the offset and message of a strict parsing error.
+func describe(err error) (int, string) {
+	var se *tags.SyntaxError
+	var ke *tags.KeyError
+	switch {
+	case errors.As(err, &se):
+		return se.Offset, se.Msg
+	case errors.As(err, &ke):
+		return ke.Offset, fmt.Sprintf("%s %q", strings.TrimPrefix(ke.Err.Error(), "tags: "), ke.Key)
+	}
+	return 0, err.Error()
+}
+
+// valueOffset returns the offset in tag of the value of key, assuming the
+// conventional `key:"value"` format, or -1.
+func valueOffset(tag, key string) int {
+	for i := 0; i < len(tag); {
+		for i < len(tag) && tag[i] == ' ' {
+			i++
+		}
+		j := strings.IndexByte(tag[i:], ':')
+		if j < 0 || i+j+1 >= len(tag) || tag[i+j+1] != '"' {
+			return -1
+		}
+		name := tag[i : i+j]
+		i += j + 2
+		if name == key {
+			return i
+		}
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		i++
+	}
+	return -1
+}
+
+// report records a diagnostic at offset in the tag value of lit, or at the
+// literal when the offset is unknown or the literal is not a raw string.
+func (c *checker) report(lit *ast.BasicLit, offset int, format string, args ...any) {
+	pos := c.fset.Position(lit.Pos())
+	if offset >= 0 && strings.HasPrefix(lit.Value, "`") {
+		pos.Column += 1 + offset
+	}
+	c.diags = append(c.diags, diagnostic{pos: pos, msg: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,114 @@
+// Command tagcheck reports struct tags that github.com/mirkobrombin/go-foundation/pkg/tags
+// would reject at run time, without running the program.
+//
+// Usage:
+//
+//	tagcheck [-schema file] [-tag name] [-test=false] [packages]
+//
+// Packages are directories, or patterns ending in "/..." to include the
+// directories below them; the default is the current directory. Every tag
+// named by the schema, or by -tag, is parsed with the rules of
+// tags.Parser.ParseStrict, and its keys are checked against the schema.
+// Diagnostics are printed vet-style, as "file:line:col: message", and the
+// exit status is 1 when there are any.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/token"
+	"io"
+	"os"
+	"slices"
+)
+
+const usage = `usage: tagcheck [-schema file] [-tag name] [-test=false] [packages]
+`
+
+var (
+	// errUsage reports a command line error; the usage has been printed.
+	errUsage = errors.New("usage")
+	// errFound is returned when diagnostics were printed.
+	errFound = errors.New("tag problems found")
+)
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case err == nil:
+	case errors.Is(err, errFound):
+		os.Exit(1)
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		os.Exit(2)
+	default:
+		fmt.Fprintln(os.Stderr, "tagcheck:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	fset := flag.NewFlagSet("tagcheck", flag.ContinueOnError)
+	fset.SetOutput(stderr)
+	fset.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fset.PrintDefaults()
+	}
+	schemaFile := fset.String("schema", "", "JSON schema of the tag `file`")
+	tagName := fset.String("tag", "", "tag `name` to check, overriding the schema")
+	tests := fset.Bool("test", true, "also check test files")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+
+	s := &schema{}
+	if *schemaFile != "" {
+		var err error
+		if s, err = loadSchema(*schemaFile); err != nil {
+			return err
+		}
+	}
+	if *tagName != "" {
+		s.Tag = *tagName
+	}
+	if s.Tag == "" {
+		fmt.Fprintln(stderr, "tagcheck: no tag name: set -tag or a schema")
+		return errUsage
+	}
+
+	patterns := fset.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	dirs, err := expand(patterns)
+	if err != nil {
+		return err
+	}
+
+	c := &checker{schema: s, parser: s.parser(), tests: *tests, fset: token.NewFileSet()}
+	for _, dir := range dirs {
+		if err := c.checkDir(dir); err != nil {
+			return err
+		}
+	}
+
+	slices.SortStableFunc(c.diags, func(a, b diagnostic) int {
+		if a.pos.Filename != b.pos.Filename {
+			if a.pos.Filename < b.pos.Filename {
+				return -1
+			}
+			return 1
+		}
+		if a.pos.Line != b.pos.Line {
+			return a.pos.Line - b.pos.Line
+		}
+		return a.pos.Column - b.pos.Column
+	})
+	for _, d := range c.diags {
+		fmt.Fprintln(stderr, d)
+	}
+	if len(c.diags) > 0 {
+		return errFound
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testSchema = `{
+  "tag": "guard",
+  "aliases": ["g"],
+  "keys": {
+    "role": {"min": 1, "max": 1, "required": true},
+    "read": {"min": 1},
+    "public": {"max": 0}
+  }
+}`
+
+const testSource = `package models
+
+import "time"
+
+type User struct {
+	Owner   string ` + "`guard:\"role:owner; read:admin,'a;b'\"`" + `
+	Typo    string ` + "`json:\"typo\" guard:\"rloe:owner\"`" + `
+	Many    string ` + "`guard:\"role:a,b\"`" + `
+	Both    string ` + "`guard:\"role:x\" g:\"role:y\"`" + `
+	Broken  string ` + "`guard:\"role:'x\"`" + `
+	Created time.Time
+}
+`
+
+const testTestSource = `package models
+
+type fixture struct {
+	Field string ` + "`guard:\"role:x; public\"`" + `
+	Inner struct {
+		Deep string ` + "`g:\"read\"`" + `
+	}
+}
+`
+
+func TestTagcheck(t *testing.T) {
+	tmp := t.TempDir()
+	schema := filepath.Join(tmp, "schema.json")
+	writeFile(t, schema, testSchema)
+	writeFile(t, filepath.Join(tmp, "src", "models", "user.go"), testSource)
+	writeFile(t, filepath.Join(tmp, "src", "models", "user_test.go"), testTestSource)
+	writeFile(t, filepath.Join(tmp, "src", "testdata", "bad.go"), "package bad\n\ntype T struct{ F int `guard:\"x\"` }\n")
+
+	src := filepath.Join(tmp, "src")
+	file := filepath.Join(src, "models", "user.go")
+	testFile := filepath.Join(src, "models", "user_test.go")
+	want := []string{
+		file + `:7:37: User.Typo: guard tag: unknown key "rloe"`,
+		file + `:8:25: User.Many: guard tag: key "role" takes 1 value(s), got 2`,
+		file + `:9:17: User.Both: g tag conflicts with guard tag`,
+		file + `:10:30: User.Broken: guard tag: unterminated quoted string`,
+		testFile + `:6:19: fixture.Deep: g tag: key "read" takes at least 1 value(s), got 0`,
+		testFile + `:6:19: fixture.Deep: g tag: missing required key "role"`,
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := run([]string{"-schema", schema, src + "/..."}, &stdout, &stderr)
+	if !errors.Is(err, errFound) {
+		t.Fatalf("got %v\n%s", err, stderr.String())
+	}
+	if got := strings.Split(strings.TrimSpace(stderr.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without test files, and with only the syntax of another tag.
+	stderr.Reset()
+	err = run([]string{"-schema", schema, "-test=false", filepath.Join(src, "models")}, &stdout, &stderr)
+	if !errors.Is(err, errFound) || strings.Contains(stderr.String(), "user_test.go") {
+		t.Errorf("-test=false: got %v\n%s", err, stderr.String())
+	}
+	stderr.Reset()
+	if err := run([]string{"-tag", "json", src + "/..."}, &stdout, &stderr); err != nil {
+		t.Errorf("-tag json: got %v\n%s", err, stderr.String())
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	if err := run(nil, &stderr, &stderr); !errors.Is(err, errUsage) {
+		t.Errorf("got %v", err)
+	}
+}
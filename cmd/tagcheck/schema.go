@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/mirkobrombin/go-foundation/pkg/tags"
+)
+
+// schema describes the tag to check. Delimiters left empty keep the
+// defaults of tags.NewParser. With no keys, any key is accepted.
+//
+// Example:
+//
+//	{
+//	  "tag": "guard",
+//	  "aliases": ["g"],
+//	  "keys": {
+//	    "role": {"min": 1, "max": 1, "required": true},
+//	    "read": {"min": 1},
+//	    "public": {"max": 0}
+//	  }
+//	}
+type schema struct {
+	Tag            string             `json:"tag"`
+	Aliases        []string           `json:"aliases"`
+	PairDelimiter  string             `json:"pair_delimiter"`
+	KVSeparator    string             `json:"kv_separator"`
+	ValueDelimiter string             `json:"value_delimiter"`
+	Keys           map[string]keySpec `json:"keys"`
+}
+
+// keySpec bounds the number of values of a key. A key written without a
+// separator has no values; a nil Max means no upper bound.
+type keySpec struct {
+	Min      int  `json:"min"`
+	Max      *int `json:"max"`
+	Required bool `json:"required"`
+}
+
+func loadSchema(name string) (*schema, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &s, nil
+}
+
+// parser returns the tags.Parser applying the rules of the schema.
+func (s *schema) parser() *tags.Parser {
+	var opts []tags.Option
+	if s.PairDelimiter != "" {
+		opts = append(opts, tags.WithPairDelimiter(s.PairDelimiter))
+	}
+	if s.KVSeparator != "" {
+		opts = append(opts, tags.WithKVSeparator(s.KVSeparator))
+	}
+	if s.ValueDelimiter != "" {
+		opts = append(opts, tags.WithValueDelimiter(s.ValueDelimiter))
+	}
+	if len(s.Keys) > 0 {
+		opts = append(opts, tags.WithAllowedKeys(s.keys()...))
+	}
+	return tags.NewParser(s.Tag, opts...)
+}
+
+// keys returns the keys of the schema in sorted order.
+func (s *schema) keys() []string {
+	keys := make([]string, 0, len(s.Keys))
+	for k := range s.Keys {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// checkArity returns a message for each key of m the schema rejects.
+func (s *schema) checkArity(m map[string][]string) []string {
+	var msgs []string
+	for _, k := range s.keys() {
+		spec := s.Keys[k]
+		vals, ok := m[k]
+		if !ok {
+			if spec.Required {
+				msgs = append(msgs, fmt.Sprintf("missing required key %q", k))
+			}
+			continue
+		}
+		n := len(vals)
+		switch {
+		case spec.Max != nil && spec.Min == *spec.Max && n != spec.Min:
+			msgs = append(msgs, fmt.Sprintf("key %q takes %d value(s), got %d", k, spec.Min, n))
+		case n < spec.Min:
+			msgs = append(msgs, fmt.Sprintf("key %q takes at least %d value(s), got %d", k, spec.Min, n))
+		case spec.Max != nil && n > *spec.Max:
+			msgs = append(msgs, fmt.Sprintf("key %q takes at most %d value(s), got %d", k, *spec.Max, n))
+		}
+	}
+	return msgs
+}
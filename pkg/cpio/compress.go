@@ -0,0 +1,156 @@
+package cpio
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mirkobrombin/go-foundation/pkg/adapters"
+)
+
+// Decompressor recognises a compressed stream by its magic bytes and
+// decodes it.
+type Decompressor struct {
+	Magic     []byte
+	NewReader func(r io.Reader) (io.Reader, error)
+}
+
+// Decompressors holds the decompressors used by WithDecompression. gzip and
+// bzip2 are registered by default; callers can register others, such as
+// zstd or xz, from their own dependencies:
+//
+//	cpio.Decompressors.Register("zstd", cpio.Decompressor{
+//		Magic:     []byte{0x28, 0xb5, 0x2f, 0xfd},
+//		NewReader: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+//	})
+var Decompressors = adapters.NewRegistry[Decompressor]()
+
+func init() {
+	Decompressors.Register("gzip", Decompressor{
+		Magic: []byte{0x1f, 0x8b},
+		NewReader: func(r io.Reader) (io.Reader, error) {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			// Stop at the end of the member so concatenated segments
+			// that follow it are read by the Reader.
+			zr.Multistream(false)
+			return zr, nil
+		},
+	})
+	Decompressors.Register("bzip2", Decompressor{
+		Magic:     []byte("BZh"),
+		NewReader: func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+	})
+}
+
+// maxLayers bounds nested compression.
+const maxLayers = 8
+
+// WithDecompression makes the reader sniff the stream and transparently
+// decompress it with the matching entry of Decompressors. Combined with
+// WithConcatenated, every segment is sniffed, so uncompressed and compressed
+// archives can follow each other.
+func WithDecompression() ReaderOption { return func(r *Reader) { r.decompress = true } }
+
+// layer is a decompressed stream stacked on top of the input.
+type layer struct {
+	br *bufio.Reader
+	rc io.Reader
+}
+
+// sniff pushes a decompression layer if the current stream starts with the
+// magic of a registered decompressor.
+func (rd *Reader) sniff() (bool, error) {
+	var (
+		match Decompressor
+		name  string
+	)
+	for _, n := range Decompressors.Names() {
+		d, _ := Decompressors.Get(n)
+		if len(d.Magic) <= len(match.Magic) {
+			continue
+		}
+		if head, _ := rd.br.Peek(len(d.Magic)); bytes.Equal(head, d.Magic) {
+			match, name = d, n
+		}
+	}
+	if match.NewReader == nil {
+		return false, nil
+	}
+	if len(rd.layers) >= maxLayers {
+		return false, fmt.Errorf("%w: more than %d compression layers", ErrInvalidHeader, maxLayers)
+	}
+	r, err := match.NewReader(rd.br)
+	if err != nil {
+		return false, fmt.Errorf("cpio: %s: %w", name, err)
+	}
+	l := layer{br: bufio.NewReader(r), rc: r}
+	rd.layers = append(rd.layers, l)
+	rd.br = l.br
+	rd.r.r = l.br
+	return true, nil
+}
+
+// pop closes the top decompression layer and resumes the stream below it.
+func (rd *Reader) pop() bool {
+	if len(rd.layers) == 0 {
+		return false
+	}
+	top := rd.layers[len(rd.layers)-1]
+	rd.layers = rd.layers[:len(rd.layers)-1]
+	if c, ok := top.rc.(io.Closer); ok {
+		_ = c.Close()
+	}
+	rd.br = rd.base
+	if len(rd.layers) > 0 {
+		rd.br = rd.layers[len(rd.layers)-1].br
+	}
+	rd.r.r = rd.br
+	return true
+}
+
+// Open opens the named archive file for reading. The returned Reader must
+// be closed with Close.
+func Open(name string, opts ...ReaderOption) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	rd := NewReader(f, opts...)
+	rd.closer = f
+	return rd, nil
+}
+
+// Close releases the decompressors of the Reader and, for readers returned
+// by Open, closes the file.
+func (rd *Reader) Close() error {
+	if rd == nil {
+		return nil
+	}
+	for rd.pop() {
+	}
+	rd.done = true
+	if rd.closer != nil {
+		err := rd.closer.Close()
+		rd.closer = nil
+		return err
+	}
+	return nil
+}
+
+// WithCompression compresses the archive through the writer returned by
+// newWriter. Close flushes and closes it after writing the trailer.
+func WithCompression(newWriter func(w io.Writer) io.WriteCloser) WriterOption {
+	return func(w *Writer) { w.compress = newWriter }
+}
+
+// WithGzip compresses the archive with gzip.
+func WithGzip() WriterOption {
+	return WithCompression(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+}
@@ -1,49 +1,225 @@
 package cpio
 
-import "io"
+import (
+	"bufio"
+	"errors"
+	"io"
+)
 
 const (
 	magicNewc = "070701"
 	magicCrc  = "070702"
 )
 
-// Entry represents a single CPIO newc entry.
+// DefaultMaxNameSize is the default limit for entry names, including the
+// trailing NUL. It matches PATH_MAX on Linux.
+const DefaultMaxNameSize = 4096
+
+// MaxFileSize is the largest entry body the headers written by Writer can
+// describe.
+const MaxFileSize = 1<<32 - 1
+
+var (
+	// ErrInvalidHeader is returned for malformed entry headers.
+	ErrInvalidHeader = errors.New("cpio: invalid header")
+	// ErrNameTooLong is returned when an entry name exceeds the name limit.
+	ErrNameTooLong = errors.New("cpio: name too long")
+	// ErrEntryTooLarge is returned when an entry body exceeds the entry limit.
+	ErrEntryTooLarge = errors.New("cpio: entry too large")
+	// ErrArchiveTooLarge is returned when the archive exceeds the size limit.
+	ErrArchiveTooLarge = errors.New("cpio: archive too large")
+	// ErrTooManyEntries is returned when the archive exceeds the entry limit.
+	ErrTooManyEntries = errors.New("cpio: too many entries")
+	// ErrFieldOverflow is returned when a value does not fit a header field
+	// of the output format.
+	ErrFieldOverflow = errors.New("cpio: header field overflow")
+	// ErrSizeMismatch is returned when an entry body is shorter or longer
+	// than the size given for it.
+	ErrSizeMismatch = errors.New("cpio: body size mismatch")
+	// ErrUnrepresentable is returned when an entry converted from another
+	// archive format cannot be stored in cpio.
+	ErrUnrepresentable = errors.New("cpio: entry not representable")
+)
+
+// Entry represents a single CPIO entry header.
 //
-// The Data field is only set for regular files.
+// The entry body is read from the Reader that returned it. For symbolic
+// links the body is the link target, which the Reader stores in Linkname.
+//
+// Hard links share Ino, DevMajor and DevMinor and have NLink > 1. Following
+// the newc convention, usually only the last link carries the file data.
+//
+// Every header field is exposed, so entries can be copied through a Writer
+// with WriteEntry without losing information.
 type Entry struct {
-	Name     string
-	Mode     uint32
-	UID      uint32
-	GID      uint32
-	NLink    uint32
-	MTime    uint32
-	FileSize uint32
-	Data     []byte
+	Name      string
+	Ino       uint32
+	Mode      uint32
+	UID       uint32
+	GID       uint32
+	NLink     uint32
+	MTime     uint32
+	FileSize  uint32
+	DevMajor  uint32
+	DevMinor  uint32
+	RDevMajor uint32
+	RDevMinor uint32
+	Check     uint32 // checksum of crc archives, zero for newc
+	Linkname  string
+	Format    Format // header format the entry was read from, ignored by Writer
+	Segment   int    // index of the concatenated archive the entry was read from
 }
 
 func (e *Entry) IsTrailer() bool { return e != nil && e.Name == "TRAILER!!!" }
 
-// Reader reads CPIO newc/crc archives.
+// LinkKey identifies the inode shared by the entries of a hard link group,
+// as the kernel does when extracting. Like the kernel, links never span
+// concatenated archives. Keys are comparable and can be used in maps.
+type LinkKey struct {
+	segment                       int
+	ino, devMajor, devMinor, mode uint32
+}
+
+// LinkKey returns the key of the hard link group e belongs to.
+func (e *Entry) LinkKey() LinkKey {
+	return LinkKey{e.Segment, e.Ino, e.DevMajor, e.DevMinor, e.Mode}
+}
+
+// Reader reads CPIO archives. The newc, crc, odc and old binary formats (in
+// either byte order) are detected from the magic of each header.
+//
+// Like archive/tar, Next returns only the entry header and the Reader itself
+// yields the body of the current entry. Unread body bytes are skipped by the
+// following call to Next.
+//
+// Header fields come from untrusted input, so the Reader enforces limits on
+// name length, entry size, archive size and entry count. Violations are
+// reported with the Err* values, which can be matched with errors.Is.
 type Reader struct {
-	r    io.Reader
-	done bool
+	r       *countingReader
+	remain  uint32 // unread body bytes of the current entry
+	pad     uint32 // alignment padding after the current body
+	entries int
+	done    bool
+
+	closer io.Closer
+
+	// Concatenated archives and decompression, see WithConcatenated and
+	// WithDecompression. br is the top of the stream: base, or the last
+	// decompression layer.
+	multi      bool
+	decompress bool
+	base       *bufio.Reader
+	br         *bufio.Reader
+	layers     []layer
+	segment    int
+	atSegment  bool
+
+	// Checksum state of the current entry, when verifying a crc archive.
+	verifyCRC bool
+	crc       bool
+	sum       uint32
+	name      string
+	check     uint32
+
+	maxName    uint32
+	maxEntry   int64
+	maxArchive int64
+	maxEntries int
+}
+
+// ReaderOption configures a Reader.
+type ReaderOption func(*Reader)
+
+// WithMaxNameSize limits entry names to n bytes including the trailing NUL
+// (default DefaultMaxNameSize).
+func WithMaxNameSize(n uint32) ReaderOption { return func(r *Reader) { r.maxName = n } }
+
+// WithMaxEntrySize limits the body size of a single entry (default unlimited).
+func WithMaxEntrySize(n int64) ReaderOption { return func(r *Reader) { r.maxEntry = n } }
+
+// WithMaxArchiveSize limits the total number of bytes read from the archive
+// (default unlimited).
+func WithMaxArchiveSize(n int64) ReaderOption { return func(r *Reader) { r.maxArchive = n } }
+
+// WithVerifyCRC makes the reader verify the checksums of crc (070702)
+// entries. A mismatch is reported as a *ChecksumError once the body has been
+// read or skipped.
+func WithVerifyCRC() ReaderOption { return func(r *Reader) { r.verifyCRC = true } }
+
+// WithMaxEntries limits the number of entries in the archive (default unlimited).
+func WithMaxEntries(n int) ReaderOption { return func(r *Reader) { r.maxEntries = n } }
+
+// WithConcatenated makes the reader continue past TRAILER!!! entries and
+// read every archive of the stream, skipping the zero padding between them,
+// as the Linux initramfs loader does. Entry.Segment reports which archive an
+// entry came from. Limits apply to the stream as a whole.
+func WithConcatenated() ReaderOption { return func(r *Reader) { r.multi = true } }
+
+func NewReader(r io.Reader, opts ...ReaderOption) *Reader {
+	rd := &Reader{maxName: DefaultMaxNameSize, atSegment: true}
+	for _, opt := range opts {
+		opt(rd)
+	}
+	if rd.multi || rd.decompress {
+		rd.base = bufio.NewReader(r)
+		rd.br = rd.base
+		r = rd.br
+	}
+	rd.r = &countingReader{r: r}
+	return rd
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
 }
 
-func NewReader(r io.Reader) *Reader { return &Reader{r: r} }
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
 
-// Writer writes CPIO newc archives.
+// Writer writes CPIO newc archives, or crc and odc archives with WithCRC
+// and WithODC.
 type Writer struct {
-	w      io.Writer
-	ino    uint32
-	uid    uint32
-	gid    uint32
-	mtime  uint32
-	closed bool
+	w        io.Writer
+	ino      uint32
+	uid      uint32
+	gid      uint32
+	mtime    uint32
+	format   Format
+	compress func(io.Writer) io.WriteCloser
+	cw       io.WriteCloser
+	closed   bool
+	err      error
+
+	// Reproducible output, see WithReproducible.
+	clamp      bool
+	clampMTime uint32
+	force      bool
+	stable     bool
+	inodes     map[linkID]uint32
+	nextIno    uint32
 }
 
 type WriterOption func(*Writer)
 
+// WithMTimeUnix sets the mtime of entries added with the Add* helpers.
 func WithMTimeUnix(mtime uint32) WriterOption { return func(w *Writer) { w.mtime = mtime } }
+
+// WithCRC makes the writer emit crc (070702) archives with per-file
+// checksums. Bodies passed to WriteEntry that are not io.ReadSeekers are
+// buffered in memory to compute the checksum before the header is written.
+func WithCRC() WriterOption { return func(w *Writer) { w.format = FormatCRC } }
+
+// WithODC makes the writer emit POSIX.1 portable ASCII (070707) archives.
+// Header values that do not fit the narrower odc fields are reported with
+// ErrFieldOverflow.
+func WithODC() WriterOption { return func(w *Writer) { w.format = FormatODC } }
+
+// WithUIDGID sets the owner of entries added with the Add* helpers.
 func WithUIDGID(uid, gid uint32) WriterOption {
 	return func(w *Writer) {
 		w.uid = uid
@@ -56,5 +232,22 @@ func NewWriter(w io.Writer, opts ...WriterOption) *Writer {
 	for _, opt := range opts {
 		opt(wr)
 	}
+	if wr.compress != nil {
+		wr.cw = wr.compress(w)
+		wr.w = wr.cw
+	}
 	return wr
 }
+
+// NewSegmentWriter returns a Writer that appends a new archive to an image
+// of size bytes, such as a microcode archive followed by the main image.
+// The image is first zero-padded to the 4-byte boundary the Linux initramfs
+// loader expects between concatenated archives.
+func NewSegmentWriter(w io.Writer, size int64, opts ...WriterOption) (*Writer, error) {
+	if p := pad4(uint32(size & 3)); p > 0 {
+		if _, err := w.Write(make([]byte, p)); err != nil {
+			return nil, err
+		}
+	}
+	return NewWriter(w, opts...), nil
+}
@@ -0,0 +1,74 @@
+package cpio
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestPackDirSpecialFilesRoundtrip(t *testing.T) {
+	tmp := t.TempDir()
+	if err := syscall.Mkfifo(filepath.Join(tmp, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	root := os.Geteuid() == 0
+	if root {
+		if err := syscall.Mknod(filepath.Join(tmp, "null"), syscall.S_IFCHR|0666, int(mkdev(1, 3))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := PackDir(tmp, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	if err := UnpackToDir(bytes.NewReader(buf.Bytes()), out); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(filepath.Join(out, "fifo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatalf("fifo: got mode %v", fi.Mode())
+	}
+	if !root {
+		return
+	}
+
+	fi, err = os.Lstat(filepath.Join(out, "null"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok := sysStat(fi)
+	if !ok || fi.Mode()&os.ModeCharDevice == 0 || st.rdevMajor != 1 || st.rdevMinor != 3 {
+		t.Fatalf("null: got mode %v rdev %d:%d", fi.Mode(), st.rdevMajor, st.rdevMinor)
+	}
+}
+
+func TestUnpackOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	e := file("su", 04755, 0, "x")
+	e.UID, e.GID = 1234, 5678
+	data := buildArchive(t, e)
+
+	out := t.TempDir()
+	if err := Unpack(bytes.NewReader(data), out, UnpackOptions{PreserveOwner: true, PreserveMode: true}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(filepath.Join(out, "su"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Uid != 1234 || st.Gid != 5678 || fi.Mode() != fs.ModeSetuid|0755 {
+		t.Fatalf("got %d:%d %v", st.Uid, st.Gid, fi.Mode())
+	}
+}
@@ -2,9 +2,15 @@ package cpio
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -32,7 +38,11 @@ func TestPackAndReadRoundtrip(t *testing.T) {
 			}
 			t.Fatal(err)
 		}
-		seen[e.Name] = e.Data
+		b, err := io.ReadAll(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[e.Name] = b
 	}
 
 	if got := string(seen["bin/hello.txt"]); got != "hello" {
@@ -40,6 +50,68 @@ func TestPackAndReadRoundtrip(t *testing.T) {
 	}
 }
 
+func TestReaderSkipsUnreadBody(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf)
+	if err := wr.AddFile("a", 0644, bytes.Repeat([]byte("a"), 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddFile("b", 0644, []byte("bbb")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd := NewReader(bytes.NewReader(buf.Bytes()))
+	e, err := rd.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "a" || e.FileSize != 1000 {
+		t.Fatalf("got %q size %d", e.Name, e.FileSize)
+	}
+	part := make([]byte, 10)
+	if _, err := io.ReadFull(rd, part); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err = rd.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "b" {
+		t.Fatalf("got %q", e.Name)
+	}
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "bbb" {
+		t.Fatalf("got %q", string(b))
+	}
+	if _, err := rd.Next(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func TestReaderTruncatedBody(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf)
+	if err := wr.AddFile("a", 0644, []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()[:buf.Len()-8]
+	rd := NewReader(bytes.NewReader(data))
+	if _, err := rd.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(rd); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
 func TestUnpackToDir(t *testing.T) {
 	tmp := t.TempDir()
 	if err := os.MkdirAll(filepath.Join(tmp, "etc"), 0755); err != nil {
@@ -66,3 +138,891 @@ func TestUnpackToDir(t *testing.T) {
 		t.Fatalf("got %q", string(b))
 	}
 }
+
+func newcHeader(namesize, filesize uint32) []byte {
+	var b bytes.Buffer
+	_ = writeNewcHeader(&b, magicNewc, &Entry{Ino: 1, Mode: 0100644, NLink: 1, FileSize: filesize}, namesize)
+	return b.Bytes()
+}
+
+func TestReaderLimits(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf)
+	for _, name := range []string{"a", "b", "c"} {
+		if err := wr.AddFile(name, 0644, bytes.Repeat([]byte("x"), 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts []ReaderOption
+		want error
+	}{
+		{"name", []ReaderOption{WithMaxNameSize(1)}, ErrNameTooLong},
+		{"entry", []ReaderOption{WithMaxEntrySize(99)}, ErrEntryTooLarge},
+		{"archive", []ReaderOption{WithMaxArchiveSize(300)}, ErrArchiveTooLarge},
+		{"entries", []ReaderOption{WithMaxEntries(2)}, ErrTooManyEntries},
+		{"within limits", []ReaderOption{WithMaxEntrySize(100), WithMaxEntries(3), WithMaxArchiveSize(int64(buf.Len()))}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd := NewReader(bytes.NewReader(buf.Bytes()), tt.opts...)
+			var err error
+			for err == nil {
+				_, err = rd.Next()
+			}
+			if tt.want == nil {
+				if err != io.EOF {
+					t.Fatalf("got %v, want io.EOF", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderHostileHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"huge namesize", newcHeader(0xFFFFFFFF, 0), ErrNameTooLong},
+		{"zero namesize", newcHeader(0, 0), ErrInvalidHeader},
+		{"bad magic", append([]byte("123456"), newcHeader(2, 0)[6:]...), ErrInvalidHeader},
+		{"bad hex", append(newcHeader(2, 0)[:14], bytes.Repeat([]byte("z"), 96)...), ErrInvalidHeader},
+		{"unterminated name", append(newcHeader(2, 0), 'a', 'b', 0, 0), ErrInvalidHeader},
+		{"huge filesize", append(newcHeader(2, 0xFFFFFFFF), 'a', 0, 0, 0), io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rd := NewReader(bytes.NewReader(tt.data))
+			_, err := rd.Next()
+			if err == nil {
+				_, err = rd.Next()
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzReader(f *testing.F) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf)
+	_ = wr.AddDir("etc", 0755)
+	_ = wr.AddFile("etc/conf", 0644, []byte("x=y"))
+	_ = wr.Close()
+	f.Add(buf.Bytes())
+	f.Add(newcHeader(0xFFFFFFFF, 0xFFFFFFFF))
+
+	const maxEntry = 1 << 20
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rd := NewReader(bytes.NewReader(data),
+			WithMaxNameSize(256),
+			WithMaxEntrySize(maxEntry),
+			WithMaxArchiveSize(int64(len(data))),
+			WithMaxEntries(64),
+		)
+		for {
+			e, err := rd.Next()
+			if err != nil {
+				return
+			}
+			if len(e.Name) >= 256 {
+				t.Fatalf("name of %d bytes exceeds limit", len(e.Name))
+			}
+			n, _ := io.Copy(io.Discard, rd)
+			if n > maxEntry || n > int64(e.FileSize) {
+				t.Fatalf("read %d bytes for entry of %d", n, e.FileSize)
+			}
+		}
+	})
+}
+
+func TestPackDirLinksRoundtrip(t *testing.T) {
+	tmp := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmp, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, "bin", "busybox"), []byte("bb"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("busybox", filepath.Join(tmp, "bin", "sh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(tmp, "bin", "busybox"), filepath.Join(tmp, "bin", "ls")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := PackDir(tmp, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rd := NewReader(bytes.NewReader(buf.Bytes()))
+	entries := map[string]*Entry{}
+	for {
+		e, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[e.Name] = e
+	}
+	if e := entries["bin/sh"]; e == nil || !e.IsSymlink() || e.Linkname != "busybox" {
+		t.Fatalf("bin/sh: got %+v", e)
+	}
+	bb, ls := entries["bin/busybox"], entries["bin/ls"]
+	if bb == nil || ls == nil || bb.Ino != ls.Ino || bb.NLink != 2 || ls.NLink != 2 {
+		t.Fatalf("hardlinks: got %+v and %+v", bb, ls)
+	}
+	if bb.FileSize+ls.FileSize != 2 || (bb.FileSize != 0 && ls.FileSize != 0) {
+		t.Fatalf("data should be on one link only: %d and %d", bb.FileSize, ls.FileSize)
+	}
+
+	out := t.TempDir()
+	if err := UnpackToDir(bytes.NewReader(buf.Bytes()), out); err != nil {
+		t.Fatal(err)
+	}
+	target, err := os.Readlink(filepath.Join(out, "bin", "sh"))
+	if err != nil || target != "busybox" {
+		t.Fatalf("readlink: got %q, %v", target, err)
+	}
+	fi1, err := os.Stat(filepath.Join(out, "bin", "busybox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi2, err := os.Stat(filepath.Join(out, "bin", "ls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(fi1, fi2) {
+		t.Fatal("bin/ls is not a hard link to bin/busybox")
+	}
+	if b, _ := os.ReadFile(filepath.Join(out, "bin", "ls")); string(b) != "bb" {
+		t.Fatalf("got %q", string(b))
+	}
+}
+
+func TestWriterSpecialFiles(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf)
+	if err := wr.AddDevice("dev/console", fs.ModeDevice|fs.ModeCharDevice|0600, 5, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddDevice("dev/sda", fs.ModeDevice|0660, 8, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddFIFO("run/fifo", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddSocket("run/sock", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddDevice("dev/bad", 0600, 1, 1); err == nil {
+		t.Fatal("expected error for non-device mode")
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]fs.FileMode{
+		"dev/console": fs.ModeDevice | fs.ModeCharDevice | 0600,
+		"dev/sda":     fs.ModeDevice | 0660,
+		"run/fifo":    fs.ModeNamedPipe | 0644,
+		"run/sock":    fs.ModeSocket | 0755,
+	}
+	rd := NewReader(bytes.NewReader(buf.Bytes()))
+	for {
+		e, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := e.FileMode(); got != want[e.Name] {
+			t.Errorf("%s: got mode %v, want %v", e.Name, got, want[e.Name])
+		}
+		if e.Name == "dev/console" && (e.RDevMajor != 5 || e.RDevMinor != 1) {
+			t.Errorf("dev/console: got rdev %d:%d", e.RDevMajor, e.RDevMinor)
+		}
+	}
+}
+
+func TestWriteEntryCopy(t *testing.T) {
+	var src bytes.Buffer
+	wr := NewWriter(&src, WithMTimeUnix(1700000000), WithUIDGID(1000, 100))
+	if err := wr.AddDir("etc", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddFile("etc/conf", 0600, []byte("x=y")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddSymlink("etc/link", "conf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddHardlink([]string{"a", "b"}, 0644, []byte("shared")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddDevice("dev/null", fs.ModeDevice|fs.ModeCharDevice|0666, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var dst bytes.Buffer
+	cp := NewWriter(&dst, WithMTimeUnix(1700000000))
+	rd := NewReader(bytes.NewReader(src.Bytes()))
+	for {
+		e, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cp.WriteEntry(e, rd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(src.Bytes(), dst.Bytes()) {
+		t.Fatal("copied archive differs from source")
+	}
+}
+
+func TestWriteEntryPerEntryFields(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithUIDGID(1, 1))
+	hdr := &Entry{
+		Name:     "./data",
+		Ino:      42,
+		Mode:     0100640,
+		UID:      1000,
+		GID:      2000,
+		NLink:    1,
+		MTime:    123456,
+		FileSize: 4,
+		DevMajor: 8,
+		DevMinor: 1,
+	}
+	if err := wr.WriteEntry(hdr, bytes.NewReader([]byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddFile("next", 0644, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.WriteEntry(&Entry{Name: "short", Mode: 0100644, FileSize: 10}, bytes.NewReader([]byte("abc"))); err == nil {
+		t.Fatal("expected error for short body")
+	}
+
+	rd := NewReader(bytes.NewReader(buf.Bytes()))
+	e, err := rd.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *e != *hdr {
+		t.Fatalf("got %+v, want %+v", *e, *hdr)
+	}
+	e, err = rd.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Ino != 43 || e.UID != 1 {
+		t.Fatalf("next: got ino %d uid %d, want 43 and 1", e.Ino, e.UID)
+	}
+}
+
+func TestCRCRoundtrip(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithCRC())
+	if err := wr.AddDir("etc", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddFile("etc/hello", 0644, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddSymlink("etc/link", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	// A non-seekable body is buffered to compute its checksum.
+	hdr := &Entry{Name: "etc/piped", Mode: 0100644, NLink: 1, FileSize: 5}
+	if err := wr.WriteEntry(hdr, io.MultiReader(bytes.NewReader([]byte("hello")))); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(magicCrc)) {
+		t.Fatalf("got magic %q", buf.Bytes()[:6])
+	}
+
+	rd := NewReader(bytes.NewReader(buf.Bytes()), WithVerifyCRC())
+	for {
+		e, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Name == "etc/hello" || e.Name == "etc/piped" {
+			// 'h'+'e'+'l'+'l'+'o'
+			if e.Check != 0x214 {
+				t.Errorf("%s: got check %08X, want 00000214", e.Name, e.Check)
+			}
+			if b, err := io.ReadAll(rd); err != nil || string(b) != "hello" {
+				t.Fatalf("%s: got %q, %v", e.Name, b, err)
+			}
+		}
+	}
+}
+
+func TestCRCCorruption(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithCRC())
+	if err := wr.AddFile("a", 0644, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddFile("b", 0644, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Replace(buf.Bytes(), []byte("hello"), []byte("jello"), 1)
+
+	// Reading the body reports the mismatch.
+	rd := NewReader(bytes.NewReader(data), WithVerifyCRC())
+	if _, err := rd.Next(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := io.ReadAll(rd)
+	var cerr *ChecksumError
+	if !errors.As(err, &cerr) || cerr.Name != "a" || !errors.Is(err, ErrChecksum) {
+		t.Fatalf("got %v, want checksum error for a", err)
+	}
+
+	// Skipping the body reports it too.
+	rd = NewReader(bytes.NewReader(data), WithVerifyCRC())
+	if _, err := rd.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rd.Next(); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("got %v, want ErrChecksum", err)
+	}
+
+	// Without verification the archive is read as is.
+	rd = NewReader(bytes.NewReader(data))
+	for err = nil; err == nil; {
+		_, err = rd.Next()
+	}
+	if err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func TestODCRoundtrip(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithODC(), WithMTimeUnix(1700000000))
+	if err := wr.AddDir("etc", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddFile("etc/hello", 0644, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddDevice("dev/tty1", fs.ModeDevice|fs.ModeCharDevice|0620, 4, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(magicODC)) {
+		t.Fatalf("got magic %q", buf.Bytes()[:6])
+	}
+
+	rd := NewReader(bytes.NewReader(buf.Bytes()))
+	var names []string
+	for {
+		e, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Format != FormatODC || e.MTime != 1700000000 {
+			t.Errorf("%s: got format %v mtime %d", e.Name, e.Format, e.MTime)
+		}
+		if e.Name == "etc/hello" {
+			if b, _ := io.ReadAll(rd); string(b) != "hello" {
+				t.Errorf("got %q", b)
+			}
+		}
+		if e.Name == "dev/tty1" && (e.RDevMajor != 4 || e.RDevMinor != 1) {
+			t.Errorf("dev/tty1: got rdev %d:%d", e.RDevMajor, e.RDevMinor)
+		}
+		names = append(names, e.Name)
+	}
+	if len(names) != 3 {
+		t.Fatalf("got %v", names)
+	}
+}
+
+func TestODCHardlinkRoundtrip(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithODC())
+	if err := wr.AddHardlink([]string{"a", "b", "c"}, 0644, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// GNU cpio links later odc records to the first and skips their data.
+	rd := NewReader(bytes.NewReader(buf.Bytes()))
+	var sizes []uint32
+	for {
+		e, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.NLink != 3 {
+			t.Errorf("%s: got nlink %d", e.Name, e.NLink)
+		}
+		sizes = append(sizes, e.FileSize)
+	}
+	if want := []uint32{4, 0, 0}; !slices.Equal(sizes, want) {
+		t.Fatalf("got sizes %v, want %v", sizes, want)
+	}
+
+	out := t.TempDir()
+	if err := UnpackToDir(bytes.NewReader(buf.Bytes()), out); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.Stat(filepath.Join(out, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		p := filepath.Join(out, name)
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := os.ReadFile(p); string(b) != "data" || !os.SameFile(fi, first) {
+			t.Errorf("%s: got %q, same file %v", name, b, os.SameFile(fi, first))
+		}
+	}
+}
+
+func TestODCOverflow(t *testing.T) {
+	wr := NewWriter(io.Discard, WithODC())
+	err := wr.WriteEntry(&Entry{Name: "big", Ino: 01000000, Mode: 0100644, NLink: 1}, nil)
+	if !errors.Is(err, ErrFieldOverflow) {
+		t.Fatalf("got %v, want ErrFieldOverflow", err)
+	}
+}
+
+func TestAddFileFrom(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithCRC())
+	body := strings.Repeat("x", 1000)
+	// Hide the Seeker so the checksum is computed from a buffered copy.
+	if err := wr.AddFileFrom("big", 0644, 1000, struct{ io.Reader }{strings.NewReader(body)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rd := NewReader(&buf, WithVerifyCRC())
+	if _, err := rd.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(rd); err != nil || string(b) != body {
+		t.Fatalf("got %d bytes, %v", len(b), err)
+	}
+}
+
+func TestAddFileFromSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		body string
+		err  error
+	}{
+		{MaxFileSize + 1, "", ErrFieldOverflow},
+		{-1, "", ErrFieldOverflow},
+		{4, "abc", ErrSizeMismatch},
+		{2, "abc", ErrSizeMismatch},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		wr := NewWriter(&buf)
+		err := wr.AddFileFrom("f", 0644, tt.size, strings.NewReader(tt.body))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("size %d: got %v, want %v", tt.size, err, tt.err)
+		}
+		if tt.err == ErrFieldOverflow && buf.Len() != 0 {
+			t.Errorf("size %d: wrote %d bytes", tt.size, buf.Len())
+		}
+	}
+}
+
+// TestSizeMismatchSticky checks that a body of the wrong size fails the
+// writer, since the archive written so far is corrupt or incomplete.
+func TestSizeMismatchSticky(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(wr *Writer) error
+	}{
+		{"short", func(wr *Writer) error {
+			return wr.AddFileFrom("f", 0644, 4, strings.NewReader("abc"))
+		}},
+		{"long", func(wr *Writer) error {
+			return wr.AddFileFrom("f", 0644, 2, strings.NewReader("abc"))
+		}},
+		{"hardlink short", func(wr *Writer) error {
+			return wr.addHardlinkFrom([]string{"a", "b"}, 0644, 4, strings.NewReader("abc"), nil)
+		}},
+		{"hardlink long", func(wr *Writer) error {
+			return wr.addHardlinkFrom([]string{"a", "b"}, 0644, 2, strings.NewReader("abc"), nil)
+		}},
+		{"entry short", func(wr *Writer) error {
+			return wr.WriteEntry(&Entry{Name: "f", Mode: modeRegular | 0644, NLink: 1, FileSize: 4}, strings.NewReader("abc"))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wr := NewWriter(io.Discard)
+			if err := tt.write(wr); !errors.Is(err, ErrSizeMismatch) {
+				t.Fatalf("got %v, want ErrSizeMismatch", err)
+			}
+			if err := wr.AddFile("g", 0644, nil); !errors.Is(err, ErrSizeMismatch) {
+				t.Errorf("AddFile: got %v, want ErrSizeMismatch", err)
+			}
+			if err := wr.Close(); !errors.Is(err, ErrSizeMismatch) {
+				t.Errorf("Close: got %v, want ErrSizeMismatch", err)
+			}
+		})
+	}
+}
+
+func TestCloseAfterErrorFlushes(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithGzip())
+	if err := wr.AddFile("a", 0644, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddFileFrom("f", 0644, 4, strings.NewReader("abc")); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("got %v, want ErrSizeMismatch", err)
+	}
+	if err := wr.Close(); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Close: got %v, want ErrSizeMismatch", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(zr); err != nil {
+		t.Errorf("compressed stream not closed: %v", err)
+	}
+}
+
+func TestAddFileFromNoOverRead(t *testing.T) {
+	r := struct{ io.Reader }{strings.NewReader("abcd")}
+	wr := NewWriter(io.Discard)
+	if err := wr.AddFileFrom("f", 0644, 2, r); err != nil {
+		t.Fatal(err)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "cd" {
+		t.Errorf("left %q in the reader, want %q", rest, "cd")
+	}
+}
+
+func TestReadODC(t *testing.T) {
+	// As written by GNU cpio -H odc: dev, ino, mode, uid, gid, nlink, rdev,
+	// mtime, namesize, filesize.
+	data := "070707" + "000000" + "000001" + "100644" + "001750" + "000144" + "000001" + "000000" +
+		"14524770400" + "000002" + "00000000003" + "a\x00" + "xyz" +
+		"070707" + "000000" + "000000" + "000000" + "000000" + "000000" + "000001" + "000000" +
+		"00000000000" + "000013" + "00000000000" + "TRAILER!!!\x00"
+
+	rd := NewReader(bytes.NewReader([]byte(data)))
+	e, err := rd.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name != "a" || e.Mode != 0100644 || e.UID != 1000 || e.GID != 100 || e.MTime != 1700000000 {
+		t.Fatalf("got %+v", e)
+	}
+	if b, _ := io.ReadAll(rd); string(b) != "xyz" {
+		t.Fatalf("got %q", b)
+	}
+	if _, err := rd.Next(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func binaryArchive(order binary.ByteOrder) []byte {
+	var b bytes.Buffer
+	add := func(name string, mode uint16, mtime uint32, data string) {
+		for _, v := range []uint16{
+			magicBinary, 0x0801, 7, mode, 1000, 100, 1, 0,
+			uint16(mtime >> 16), uint16(mtime), uint16(len(name) + 1),
+			uint16(len(data) >> 16), uint16(len(data)),
+		} {
+			_ = binary.Write(&b, order, v)
+		}
+		b.WriteString(name)
+		b.WriteByte(0)
+		if (26+len(name)+1)%2 != 0 {
+			b.WriteByte(0)
+		}
+		b.WriteString(data)
+		if len(data)%2 != 0 {
+			b.WriteByte(0)
+		}
+	}
+	add("bin", 040755, 1700000000, "")
+	add("bin/true", 0100755, 1700000000, "odd")
+	add("TRAILER!!!", 0, 0, "")
+	return b.Bytes()
+}
+
+func TestReadBinary(t *testing.T) {
+	for _, tt := range []struct {
+		order  binary.ByteOrder
+		format Format
+	}{
+		{binary.LittleEndian, FormatBinaryLE},
+		{binary.BigEndian, FormatBinaryBE},
+	} {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			rd := NewReader(bytes.NewReader(binaryArchive(tt.order)))
+			e, err := rd.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Name != "bin" || !e.IsDir() || e.Format != tt.format || e.MTime != 1700000000 {
+				t.Fatalf("got %+v", e)
+			}
+			if e.DevMajor != 8 || e.DevMinor != 1 {
+				t.Fatalf("got dev %d:%d", e.DevMajor, e.DevMinor)
+			}
+			e, err = rd.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e.Name != "bin/true" || e.UID != 1000 {
+				t.Fatalf("got %+v", e)
+			}
+			if b, _ := io.ReadAll(rd); string(b) != "odd" {
+				t.Fatalf("got %q", b)
+			}
+			if _, err := rd.Next(); err != io.EOF {
+				t.Fatalf("got %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestConcatenatedArchives(t *testing.T) {
+	var img bytes.Buffer
+	wr := NewWriter(&img, WithODC())
+	if err := wr.AddFile("kernel/x86/microcode/GenuineIntel.bin", 0644, []byte("ucode")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Extra block padding, as written by GNU cpio.
+	img.Write(make([]byte, 509))
+
+	wr, err := NewSegmentWriter(&img, int64(img.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Len()%4 != 0 {
+		t.Fatalf("segment starts at unaligned offset %d", img.Len())
+	}
+	if err := wr.AddFile("init", 0755, []byte("#!/bin/sh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(opts ...ReaderOption) map[string]int {
+		rd := NewReader(bytes.NewReader(img.Bytes()), opts...)
+		seen := map[string]int{}
+		for {
+			e, err := rd.Next()
+			if err == io.EOF {
+				return seen
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[e.Name] = e.Segment
+		}
+	}
+
+	if seen := read(); len(seen) != 1 {
+		t.Fatalf("default reader: got %v, want first segment only", seen)
+	}
+	seen := read(WithConcatenated())
+	if len(seen) != 2 || seen["init"] != 1 || seen["kernel/x86/microcode/GenuineIntel.bin"] != 0 {
+		t.Fatalf("got %v", seen)
+	}
+
+	out := t.TempDir()
+	if err := UnpackToDir(bytes.NewReader(img.Bytes()), out, WithConcatenated()); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "init")); err != nil || string(b) != "#!/bin/sh" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+}
+
+func TestGzipPackAndRead(t *testing.T) {
+	tmp := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmp, "init"), []byte("#!/bin/sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	img := filepath.Join(t.TempDir(), "initrd.img")
+	f, err := os.Create(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PackDir(tmp, f, WithGzip()); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := Open(img, WithDecompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rd.Close()
+	e, err := rd.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(rd); e.Name != "init" || string(b) != "#!/bin/sh" {
+		t.Fatalf("got %s: %q", e.Name, b)
+	}
+	if _, err := rd.Next(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func TestConcatenatedCompressedSegments(t *testing.T) {
+	var img bytes.Buffer
+	wr := NewWriter(&img)
+	if err := wr.AddFile("early", 0644, []byte("ucode")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	wr, err := NewSegmentWriter(&img, int64(img.Len()), WithGzip())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddFile("main", 0644, []byte("rootfs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	img.Write(make([]byte, 16))
+	wr, err = NewSegmentWriter(&img, int64(img.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.AddFile("late", 0644, []byte("extra")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd := NewReader(bytes.NewReader(img.Bytes()), WithConcatenated(), WithDecompression())
+	var got []string
+	for {
+		e, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e.Name+"="+string(b))
+	}
+	want := []string{"early=ucode", "main=rootfs", "late=extra"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRegisterDecompressor(t *testing.T) {
+	magic := []byte("TEST")
+	Decompressors.Register("test", Decompressor{
+		Magic: magic,
+		NewReader: func(r io.Reader) (io.Reader, error) {
+			if _, err := io.ReadFull(r, make([]byte, len(magic))); err != nil {
+				return nil, err
+			}
+			return r, nil
+		},
+	})
+	defer Decompressors.Remove("test")
+
+	var buf bytes.Buffer
+	buf.Write(magic)
+	wr := NewWriter(&buf)
+	if err := wr.AddFile("a", 0644, []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd := NewReader(bytes.NewReader(buf.Bytes()), WithDecompression())
+	if e, err := rd.Next(); err != nil || e.Name != "a" {
+		t.Fatalf("got %v, %v", e, err)
+	}
+}
@@ -0,0 +1,69 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrChecksum is matched by ChecksumError with errors.Is.
+var ErrChecksum = errors.New("cpio: checksum mismatch")
+
+// ChecksumError reports an entry of a crc (070702) archive whose body does
+// not match the checksum in its header.
+type ChecksumError struct {
+	Name string
+	Want uint32
+	Got  uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("cpio: checksum mismatch for %q: header %08X, body %08X", e.Name, e.Want, e.Got)
+}
+
+func (e *ChecksumError) Unwrap() error { return ErrChecksum }
+
+// checksum computes the crc format checksum: the sum of all body bytes,
+// truncated to 32 bits.
+func checksum(sum uint32, p []byte) uint32 {
+	for _, b := range p {
+		sum += uint32(b)
+	}
+	return sum
+}
+
+type checksumWriter struct{ sum uint32 }
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	c.sum = checksum(c.sum, p)
+	return len(p), nil
+}
+
+// checksumBody computes the checksum of the next size bytes of body and
+// returns a reader that yields the same bytes again. Seekable bodies are
+// rewound; others are buffered in memory.
+func checksumBody(body io.Reader, size uint32) (uint32, io.Reader, error) {
+	if size == 0 || body == nil {
+		return 0, body, nil
+	}
+	var c checksumWriter
+	if rs, ok := body.(io.ReadSeeker); ok {
+		pos, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, nil, err
+		}
+		if _, err := io.CopyN(&c, rs, int64(size)); err != nil && !errors.Is(err, io.EOF) {
+			return 0, nil, err
+		}
+		if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+			return 0, nil, err
+		}
+		return c.sum, rs, nil
+	}
+	b, err := io.ReadAll(io.LimitReader(body, int64(size)))
+	if err != nil {
+		return 0, nil, err
+	}
+	return checksum(0, b), bytes.NewReader(b), nil
+}
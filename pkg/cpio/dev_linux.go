@@ -0,0 +1,24 @@
+package cpio
+
+import "syscall"
+
+// devMajor extracts the major number from a Linux dev_t.
+func devMajor(dev uint64) uint32 {
+	return uint32((dev>>8)&0xfff | (dev>>32)&^0xfff)
+}
+
+// devMinor extracts the minor number from a Linux dev_t.
+func devMinor(dev uint64) uint32 {
+	return uint32(dev&0xff | (dev>>12)&^0xff)
+}
+
+// mkdev builds a Linux dev_t from major and minor numbers.
+func mkdev(major, minor uint32) uint64 {
+	maj, min := uint64(major), uint64(minor)
+	return (maj&0xfff)<<8 | (maj&^0xfff)<<32 | min&0xff | (min&^0xff)<<12
+}
+
+// mknod creates a device node, FIFO or socket. mode is a cpio mode.
+func mknod(path string, mode, major, minor uint32) error {
+	return syscall.Mknod(path, mode, int(mkdev(major, minor)))
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package cpio
+
+import (
+	"errors"
+	"fmt"
+)
+
+// devMajor extracts the major number from a BSD-style dev_t.
+func devMajor(dev uint64) uint32 { return uint32(dev>>24) & 0xff }
+
+// devMinor extracts the minor number from a BSD-style dev_t.
+func devMinor(dev uint64) uint32 { return uint32(dev) & 0xffffff }
+
+// mknod is only implemented on Linux.
+func mknod(path string, mode, major, minor uint32) error {
+	return fmt.Errorf("cpio: creating special file %s: %w", path, errors.ErrUnsupported)
+}
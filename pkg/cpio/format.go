@@ -0,0 +1,177 @@
+package cpio
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Format identifies a cpio header format.
+type Format int
+
+const (
+	// FormatNewc is the SVR4 portable ASCII format ("070701").
+	FormatNewc Format = iota
+	// FormatCRC is FormatNewc with per-file checksums ("070702").
+	FormatCRC
+	// FormatODC is the POSIX.1 portable ASCII format ("070707").
+	FormatODC
+	// FormatBinaryLE is the old binary format in little-endian byte order.
+	FormatBinaryLE
+	// FormatBinaryBE is the old binary format in big-endian byte order.
+	FormatBinaryBE
+)
+
+const magicODC = "070707"
+
+// magicBinary is the old binary magic, 070707 in octal.
+const magicBinary = 0x71C7
+
+func (f Format) String() string {
+	switch f {
+	case FormatNewc:
+		return "newc"
+	case FormatCRC:
+		return "crc"
+	case FormatODC:
+		return "odc"
+	case FormatBinaryLE:
+		return "binary (little-endian)"
+	case FormatBinaryBE:
+		return "binary (big-endian)"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// headerSize returns the size of the fixed header preceding the name.
+func (f Format) headerSize() uint32 {
+	switch f {
+	case FormatODC:
+		return 76
+	case FormatBinaryLE, FormatBinaryBE:
+		return 26
+	}
+	return 110
+}
+
+// pad returns the padding needed after n bytes of header+name or body.
+func (f Format) pad(n uint32) uint32 {
+	switch f {
+	case FormatODC:
+		return 0
+	case FormatBinaryLE, FormatBinaryBE:
+		return n & 1
+	}
+	return pad4(n)
+}
+
+// encodeDev and decodeDev convert between major/minor numbers and the
+// single device field of the odc and binary formats, using the Linux
+// encoding (8-bit minor and 12-bit major in the low 20 bits).
+func encodeDev(major, minor uint32) uint32 {
+	return minor&0xff | major<<8 | (minor&^0xff)<<12
+}
+
+func decodeDev(dev uint32) (major, minor uint32) {
+	return (dev & 0xfff00) >> 8, dev&0xff | (dev>>12)&0xfff00
+}
+
+// parseNewcHeader parses the fields after the magic of a newc/crc header.
+func parseNewcHeader(b []byte) (*Entry, uint32, error) {
+	var f [13]uint32
+	for i := range f {
+		v, err := parseHex8(b[8*i : 8+8*i])
+		if err != nil {
+			return nil, 0, err
+		}
+		f[i] = v
+	}
+	return &Entry{
+		Ino:       f[hIno],
+		Mode:      f[hMode],
+		UID:       f[hUID],
+		GID:       f[hGID],
+		NLink:     f[hNLink],
+		MTime:     f[hMTime],
+		FileSize:  f[hFileSize],
+		DevMajor:  f[hDevMajor],
+		DevMinor:  f[hDevMinor],
+		RDevMajor: f[hRDevMajor],
+		RDevMinor: f[hRDevMinor],
+		Check:     f[hCheck],
+	}, f[hNameSize], nil
+}
+
+// odcWidths are the octal field widths of an odc header after the magic:
+// dev, ino, mode, uid, gid, nlink, rdev, mtime, namesize, filesize.
+var odcWidths = [10]int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11}
+
+// parseODCHeader parses the fields after the magic of an odc header.
+func parseODCHeader(b []byte) (*Entry, uint32, error) {
+	var f [10]uint64
+	off := 0
+	for i, w := range odcWidths {
+		v, err := parseOctal(b[off : off+w])
+		if err != nil {
+			return nil, 0, err
+		}
+		f[i] = v
+		off += w
+	}
+	if f[7] > 0xFFFFFFFF || f[9] > 0xFFFFFFFF {
+		return nil, 0, fmt.Errorf("%w: odc field exceeds 32 bits", ErrInvalidHeader)
+	}
+	e := &Entry{
+		Ino:      uint32(f[1]),
+		Mode:     uint32(f[2]),
+		UID:      uint32(f[3]),
+		GID:      uint32(f[4]),
+		NLink:    uint32(f[5]),
+		MTime:    uint32(f[7]),
+		FileSize: uint32(f[9]),
+	}
+	e.DevMajor, e.DevMinor = decodeDev(uint32(f[0]))
+	e.RDevMajor, e.RDevMinor = decodeDev(uint32(f[6]))
+	return e, uint32(f[8]), nil
+}
+
+// parseBinaryHeader parses an old binary header, including the magic.
+func parseBinaryHeader(b []byte, order binary.ByteOrder) (*Entry, uint32, error) {
+	var f [13]uint16
+	for i := range f {
+		f[i] = order.Uint16(b[2*i:])
+	}
+	e := &Entry{
+		Ino:      uint32(f[2]),
+		Mode:     uint32(f[3]),
+		UID:      uint32(f[4]),
+		GID:      uint32(f[5]),
+		NLink:    uint32(f[6]),
+		MTime:    uint32(f[8])<<16 | uint32(f[9]),
+		FileSize: uint32(f[11])<<16 | uint32(f[12]),
+	}
+	e.DevMajor, e.DevMinor = decodeDev(uint32(f[1]))
+	e.RDevMajor, e.RDevMinor = decodeDev(uint32(f[7]))
+	return e, uint32(f[10]), nil
+}
+
+func parseOctal(b []byte) (uint64, error) {
+	var v uint64
+	for _, c := range b {
+		if c < '0' || c > '7' {
+			return 0, fmt.Errorf("%w: invalid octal", ErrInvalidHeader)
+		}
+		v = v<<3 | uint64(c-'0')
+	}
+	return v, nil
+}
+
+// appendOctal appends v as a zero-padded octal number of width digits.
+func appendOctal(dst []byte, v uint64, width int) ([]byte, bool) {
+	if width < 22 && v >= 1<<(3*width) {
+		return dst, false
+	}
+	for i := width - 1; i >= 0; i-- {
+		dst = append(dst, byte('0'+(v>>(3*i))&7))
+	}
+	return dst, true
+}
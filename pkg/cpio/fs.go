@@ -0,0 +1,350 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxSymlinkHops bounds symlink resolution, like the kernel's MAXSYMLINKS.
+const maxSymlinkHops = 40
+
+// FS is a read-only fs.FS view of a cpio archive. It implements
+// fs.ReadDirFS, fs.ReadFileFS and fs.StatFS, and the ReadLink and Lstat
+// methods of fs.ReadLinkFS.
+//
+// Symbolic links are resolved inside the archive: absolute targets are
+// relative to the archive root and no target can escape it. Directories that
+// are implied by entry names but missing from the archive are synthesised.
+type FS struct {
+	ra    io.ReaderAt
+	files map[string]*fsEntry
+}
+
+// fsEntry is an indexed archive entry. Bodies are either read lazily from
+// the archive at offset or kept in data.
+type fsEntry struct {
+	hdr      Entry
+	offset   int64
+	data     []byte
+	children []*fsEntry // sorted by name, directories only
+}
+
+// NewFS indexes the archive in ra with a single pass over its headers.
+// File contents are read lazily from ra. Reader options apply to the
+// indexing pass; WithDecompression is not supported, use LoadFS instead.
+func NewFS(ra io.ReaderAt, size int64, opts ...ReaderOption) (*FS, error) {
+	rd := NewReader(io.NewSectionReader(ra, 0, size), opts...)
+	if rd.decompress {
+		return nil, errors.New("cpio: NewFS needs an uncompressed archive, use LoadFS")
+	}
+	return buildFS(rd, ra, false)
+}
+
+// LoadFS reads a whole archive from r, keeping file contents in memory. It
+// accepts any Reader option, including WithDecompression.
+func LoadFS(r io.Reader, opts ...ReaderOption) (*FS, error) {
+	return buildFS(NewReader(r, opts...), nil, true)
+}
+
+func buildFS(rd *Reader, ra io.ReaderAt, load bool) (*FS, error) {
+	fsys := &FS{ra: ra, files: map[string]*fsEntry{}}
+	fsys.files["."] = &fsEntry{hdr: Entry{Name: ".", Mode: modeDir | 0755, NLink: 2}}
+
+	type linkGroup struct{ entries []*fsEntry }
+	links := map[LinkKey]*linkGroup{}
+
+	for {
+		e, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := cleanFSName(e.Name)
+		if name == "" {
+			continue
+		}
+		fe := &fsEntry{hdr: *e, offset: rd.r.n}
+		fe.hdr.Name = name
+		if load && e.IsRegular() && e.FileSize > 0 {
+			if fe.data, err = io.ReadAll(rd); err != nil {
+				return nil, err
+			}
+		}
+		if name == "." && !e.IsDir() {
+			continue
+		}
+		fsys.files[name] = fe
+
+		if e.IsRegular() && e.NLink > 1 {
+			key := e.LinkKey()
+			g := links[key]
+			if g == nil {
+				g = &linkGroup{}
+				links[key] = g
+			}
+			g.entries = append(g.entries, fe)
+		}
+	}
+
+	// Hard links share the body carried by one of them.
+	for _, g := range links {
+		var src *fsEntry
+		for _, fe := range g.entries {
+			if fe.hdr.FileSize > 0 {
+				src = fe
+			}
+		}
+		if src == nil {
+			continue
+		}
+		for _, fe := range g.entries {
+			fe.hdr.FileSize, fe.offset, fe.data = src.hdr.FileSize, src.offset, src.data
+		}
+	}
+
+	names := make([]string, 0, len(fsys.files))
+	for name := range fsys.files {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		fsys.addParents(name)
+	}
+	for _, fe := range fsys.files {
+		if fe.hdr.Name == "." {
+			continue
+		}
+		parent := fsys.files[path.Dir(fe.hdr.Name)]
+		parent.children = append(parent.children, fe)
+	}
+	for _, fe := range fsys.files {
+		slices.SortFunc(fe.children, func(a, b *fsEntry) int {
+			return strings.Compare(path.Base(a.hdr.Name), path.Base(b.hdr.Name))
+		})
+	}
+	return fsys, nil
+}
+
+// addParents synthesises missing parent directories of name. A parent that
+// exists but is not a directory is replaced, as extraction would fail.
+func (fsys *FS) addParents(name string) {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if fe, ok := fsys.files[dir]; ok && fe.hdr.IsDir() {
+			return
+		}
+		fsys.files[dir] = &fsEntry{hdr: Entry{Name: dir, Mode: modeDir | 0755, NLink: 2}}
+	}
+}
+
+// cleanFSName converts an archive name to an fs.FS path, or "" if it is
+// not representable.
+func cleanFSName(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "."
+	}
+	name = name[1:]
+	if !fs.ValidPath(name) {
+		return ""
+	}
+	return name
+}
+
+// lookup finds name, resolving symlinks in every component but the last,
+// which is only resolved when follow is set.
+func (fsys *FS) lookup(op, name string, follow bool) (*fsEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	var parts []string
+	if name != "." {
+		parts = strings.Split(name, "/")
+	}
+	cur, hops := ".", 0
+	for len(parts) > 0 {
+		next := path.Join(cur, parts[0])
+		parts = parts[1:]
+		fe, ok := fsys.files[next]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		if fe.hdr.IsSymlink() && (len(parts) > 0 || follow) {
+			if hops++; hops > maxSymlinkHops {
+				return nil, &fs.PathError{Op: op, Path: name, Err: errors.New("too many levels of symbolic links")}
+			}
+			base := cur
+			if path.IsAbs(fe.hdr.Linkname) {
+				base = "."
+			}
+			// Cleaning against "/" keeps ".." from climbing out of the root.
+			target := strings.TrimPrefix(path.Join("/", base, fe.hdr.Linkname), "/")
+			if target != "" {
+				parts = append(strings.Split(target, "/"), parts...)
+			}
+			cur = "."
+			continue
+		}
+		if len(parts) > 0 && !fe.hdr.IsDir() {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		cur = next
+	}
+	return fsys.files[cur], nil
+}
+
+// Open implements fs.FS. Symbolic links are followed.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	fe, err := fsys.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info := fileInfo{name: path.Base(name), e: fe}
+	if fe.hdr.IsDir() {
+		return &fsDir{info: info, children: fe.children}, nil
+	}
+	return &fsFile{info: info, r: fsys.body(fe)}, nil
+}
+
+func (fsys *FS) body(fe *fsEntry) *io.SectionReader {
+	if !fe.hdr.IsRegular() {
+		return io.NewSectionReader(bytes.NewReader(nil), 0, 0)
+	}
+	if fe.data != nil || fsys.ra == nil {
+		return io.NewSectionReader(bytes.NewReader(fe.data), 0, int64(len(fe.data)))
+	}
+	return io.NewSectionReader(fsys.ra, fe.offset, int64(fe.hdr.FileSize))
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	fe, err := fsys.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !fe.hdr.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return dirEntries(fe.children), nil
+}
+
+// ReadFile implements fs.ReadFileFS.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	fe, err := fsys.lookup("readfile", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if fe.hdr.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+	}
+	r := fsys.body(fe)
+	b := make([]byte, r.Size())
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return b, nil
+}
+
+// Stat implements fs.StatFS. Symbolic links are followed.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	fe, err := fsys.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{name: path.Base(name), e: fe}, nil
+}
+
+// Lstat returns the FileInfo of name without following a final symlink.
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	fe, err := fsys.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{name: path.Base(name), e: fe}, nil
+}
+
+// ReadLink returns the target of the symbolic link name.
+func (fsys *FS) ReadLink(name string) (string, error) {
+	fe, err := fsys.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if !fe.hdr.IsSymlink() {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return fe.hdr.Linkname, nil
+}
+
+type fileInfo struct {
+	name string
+	e    *fsEntry
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) Size() int64        { return int64(fi.e.hdr.FileSize) }
+func (fi fileInfo) Mode() fs.FileMode  { return fi.e.hdr.FileMode() }
+func (fi fileInfo) ModTime() time.Time { return time.Unix(int64(fi.e.hdr.MTime), 0) }
+func (fi fileInfo) IsDir() bool        { return fi.e.hdr.IsDir() }
+
+// Sys returns the *Entry of the file.
+func (fi fileInfo) Sys() any { return &fi.e.hdr }
+
+func dirEntries(children []*fsEntry) []fs.DirEntry {
+	out := make([]fs.DirEntry, len(children))
+	for i, c := range children {
+		out[i] = fs.FileInfoToDirEntry(fileInfo{name: path.Base(c.hdr.Name), e: c})
+	}
+	return out
+}
+
+// fsFile is an open regular file or special file of an FS.
+type fsFile struct {
+	info fileInfo
+	r    *io.SectionReader
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fsFile) Read(p []byte) (int, error) { return f.r.Read(p) }
+func (f *fsFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.r.ReadAt(p, off)
+}
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	return f.r.Seek(offset, whence)
+}
+func (f *fsFile) Close() error { return nil }
+
+// fsDir is an open directory of an FS.
+type fsDir struct {
+	info     fileInfo
+	children []*fsEntry
+	pos      int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+func (d *fsDir) Close() error { return nil }
+
+// ReadDir implements fs.ReadDirFile.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.children[d.pos:]
+	if n <= 0 {
+		d.pos = len(d.children)
+		return dirEntries(rest), nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.pos += n
+	return dirEntries(rest[:n]), nil
+}
@@ -0,0 +1,97 @@
+package cpio
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func testFSArchive(t *testing.T, opts ...WriterOption) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, opts...)
+	steps := []func() error{
+		func() error { return wr.AddDir("etc", 0755) },
+		func() error { return wr.AddFile("etc/hostname", 0644, []byte("box\n")) },
+		func() error { return wr.AddFile("usr/bin/busybox", 0755, []byte("elf")) },
+		func() error { return wr.AddSymlink("bin", "usr/bin") },
+		func() error { return wr.AddSymlink("usr/bin/sh", "/usr/bin/busybox") },
+		func() error { return wr.AddSymlink("escape", "../../../etc/hostname") },
+		func() error { return wr.AddHardlink([]string{"a", "b"}, 0644, []byte("linked")) },
+		func() error { return wr.AddFIFO("run/fifo", 0600) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFS(t *testing.T) {
+	data := testFSArchive(t)
+	fsys, err := NewFS(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "etc/hostname", "usr/bin/busybox", "usr/bin/sh", "a", "b", "run/fifo"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"etc/hostname": "box\n",
+		"bin/sh":       "elf",
+		"escape":       "box\n",
+		"a":            "linked",
+		"b":            "linked",
+	}
+	for name, want := range tests {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	target, err := fsys.ReadLink("bin")
+	if err != nil || target != "usr/bin" {
+		t.Fatalf("readlink: got %q, %v", target, err)
+	}
+	fi, err := fsys.Lstat("bin")
+	if err != nil || fi.Mode().Type() != fs.ModeSymlink {
+		t.Fatalf("lstat: got %v, %v", fi, err)
+	}
+	fi, err = fsys.Stat("bin")
+	if err != nil || !fi.IsDir() {
+		t.Fatalf("stat: got %v, %v", fi, err)
+	}
+	entries, err := fsys.ReadDir("usr")
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		t.Fatalf("synthesised usr: got %v, %v", entries, err)
+	}
+}
+
+func TestLoadFSCompressed(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithGzip())
+	if err := wr.AddFile("init", 0755, []byte("#!/bin/sh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()), WithDecompression()); err == nil {
+		t.Fatal("expected NewFS to reject decompression")
+	}
+	fsys, err := LoadFS(&buf, WithDecompression())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, "init"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,357 @@
+package cpio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ManifestName is the name of the entry EmbedManifest adds to an archive.
+// It is left out of the manifests computed for archives and directories.
+const ManifestName = ".cpio-manifest.json"
+
+// ErrManifestMismatch is returned when an archive or directory does not
+// match its manifest.
+var ErrManifestMismatch = errors.New("cpio: manifest mismatch")
+
+// ManifestError lists the differences found by a manifest verification.
+type ManifestError struct {
+	Problems []string
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("cpio: manifest mismatch: %s", strings.Join(e.Problems, "; "))
+}
+
+func (e *ManifestError) Unwrap() error { return ErrManifestMismatch }
+
+// Manifest lists the contents of an archive, so that the archive or a
+// directory extracted from it can be checked against a trusted copy.
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry describes one path. Mode is the octal cpio mode. Regular
+// files have the SHA-256 of their contents and symbolic links that of their
+// target; hard links each carry the contents of their group.
+type ManifestEntry struct {
+	Path      string `json:"path"`
+	Mode      string `json:"mode"`
+	UID       uint32 `json:"uid"`
+	GID       uint32 `json:"gid"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256,omitempty"`
+	RDevMajor uint32 `json:"rdev_major,omitempty"`
+	RDevMinor uint32 `json:"rdev_minor,omitempty"`
+}
+
+// ManifestFields selects the attributes a verification compares.
+type ManifestFields uint8
+
+const (
+	// ManifestMode compares file types and mode bits.
+	ManifestMode ManifestFields = 1 << iota
+	// ManifestOwner compares uid and gid.
+	ManifestOwner
+	// ManifestContent compares sizes, digests and device numbers.
+	ManifestContent
+
+	ManifestAll = ManifestMode | ManifestOwner | ManifestContent
+)
+
+// NewManifest computes the manifest of the archive read from r.
+func NewManifest(r io.Reader, opts ...ReaderOption) (*Manifest, error) {
+	rd := NewReader(r, opts...)
+	b := newManifestBuilder()
+	for {
+		e, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			return b.manifest(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, rd); err != nil {
+			return nil, err
+		}
+		b.add(e, h)
+	}
+}
+
+// EmbedManifest copies the archive read from r to w, adding its manifest
+// as a ManifestName entry at the end, and returns the manifest. An existing
+// ManifestName entry is dropped. The manifest can be read back with
+// FS.ReadFile(ManifestName) and ReadManifest.
+func EmbedManifest(r io.Reader, w io.Writer, opts ...ReaderOption) (*Manifest, error) {
+	rd := NewReader(r, opts...)
+	b := newManifestBuilder()
+	var wr *Writer
+	for {
+		e, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if wr == nil {
+			wr = newFormatWriter(w, e.Format)
+		}
+		if cleanFSName(e.Name) == ManifestName {
+			continue
+		}
+		h := sha256.New()
+		if err := wr.WriteEntry(e, io.TeeReader(rd, h)); err != nil {
+			return nil, err
+		}
+		b.add(e, h)
+	}
+	if wr == nil {
+		wr = NewWriter(w)
+	}
+
+	m := b.manifest()
+	data, err := m.MarshalIndent()
+	if err != nil {
+		return nil, err
+	}
+	hdr := &Entry{Name: ManifestName, Ino: wr.ino, Mode: modeRegular | 0444, NLink: 1, FileSize: uint32(len(data))}
+	if err := wr.WriteEntry(hdr, strings.NewReader(string(data))); err != nil {
+		return nil, err
+	}
+	return m, wr.Close()
+}
+
+// ReadManifest decodes a JSON manifest. A mode that is not an octal
+// number is an error.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("cpio: manifest: %w", err)
+	}
+	for _, e := range m.Entries {
+		if _, err := parseMode(e.Mode); err != nil {
+			return nil, fmt.Errorf("cpio: manifest: %s: %w", e.Path, err)
+		}
+	}
+	return &m, nil
+}
+
+// MarshalIndent encodes the manifest as indented JSON. Entries are sorted
+// by path, so equal manifests always encode to the same bytes.
+func (m *Manifest) MarshalIndent() ([]byte, error) {
+	sorted := Manifest{Entries: slices.Clone(m.Entries)}
+	slices.SortStableFunc(sorted.Entries, func(a, b ManifestEntry) int { return strings.Compare(a.Path, b.Path) })
+	b, err := json.MarshalIndent(&sorted, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// VerifyArchive checks the archive read from r against m, comparing the
+// given fields. Differences are reported with a *ManifestError.
+func (m *Manifest) VerifyArchive(r io.Reader, fields ManifestFields, opts ...ReaderOption) error {
+	got, err := NewManifest(r, opts...)
+	if err != nil {
+		return err
+	}
+	return m.verify(got, fields)
+}
+
+// VerifyDir checks a directory extracted from an archive against m,
+// comparing the given fields. Modes and ownership only match when the
+// archive was extracted with PreserveMode and PreserveOwner. Directories
+// not listed in m, such as parents the archive did not contain, are
+// ignored; any other extra file is reported.
+func (m *Manifest) VerifyDir(dir string, fields ManifestFields) error {
+	got, err := dirManifest(dir)
+	if err != nil {
+		return err
+	}
+	listed := map[string]bool{}
+	for _, e := range m.Entries {
+		listed[e.Path] = true
+	}
+	kept := got.Entries[:0]
+	for _, e := range got.Entries {
+		mode, err := parseMode(e.Mode)
+		if err != nil {
+			return err
+		}
+		if listed[e.Path] || !fileMode(mode).IsDir() {
+			kept = append(kept, e)
+		}
+	}
+	got.Entries = kept
+	return m.verify(got, fields)
+}
+
+func (m *Manifest) verify(got *Manifest, fields ManifestFields) error {
+	want := map[string]ManifestEntry{}
+	for _, e := range m.Entries {
+		want[e.Path] = e
+	}
+	var problems []string
+	for _, g := range got.Entries {
+		w, ok := want[g.Path]
+		if !ok {
+			problems = append(problems, g.Path+": not in manifest")
+			continue
+		}
+		delete(want, g.Path)
+		if fields&ManifestMode != 0 && g.Mode != w.Mode {
+			problems = append(problems, fmt.Sprintf("%s: mode %s, want %s", g.Path, g.Mode, w.Mode))
+		}
+		if fields&ManifestOwner != 0 && (g.UID != w.UID || g.GID != w.GID) {
+			problems = append(problems, fmt.Sprintf("%s: owner %d:%d, want %d:%d", g.Path, g.UID, g.GID, w.UID, w.GID))
+		}
+		if fields&ManifestContent != 0 && (g.Size != w.Size || g.SHA256 != w.SHA256 || g.RDevMajor != w.RDevMajor || g.RDevMinor != w.RDevMinor) {
+			problems = append(problems, g.Path+": content differs")
+		}
+	}
+	for _, e := range m.Entries {
+		if _, ok := want[e.Path]; ok {
+			problems = append(problems, e.Path+": missing")
+		}
+	}
+	if len(problems) > 0 {
+		return &ManifestError{Problems: problems}
+	}
+	return nil
+}
+
+func parseMode(s string) (uint32, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(mode), nil
+}
+
+// manifestBuilder collects manifest entries from archive entries.
+type manifestBuilder struct {
+	entries map[string]*ManifestEntry
+	groups  map[LinkKey][]*ManifestEntry
+}
+
+func newManifestBuilder() *manifestBuilder {
+	return &manifestBuilder{entries: map[string]*ManifestEntry{}, groups: map[LinkKey][]*ManifestEntry{}}
+}
+
+// add records e, whose body has been written to h. Later entries with the
+// same path replace earlier ones, as they do when extracting.
+func (b *manifestBuilder) add(e *Entry, h hash.Hash) {
+	name := cleanFSName(e.Name)
+	if name == "" || name == "." || name == ManifestName {
+		return
+	}
+	me := &ManifestEntry{
+		Path: name,
+		Mode: fmt.Sprintf("%o", e.Mode),
+		UID:  e.UID,
+		GID:  e.GID,
+	}
+	switch e.Mode & modeTypeMask {
+	case modeRegular:
+		me.Size, me.SHA256 = int64(e.FileSize), hex.EncodeToString(h.Sum(nil))
+	case modeSymlink:
+		h.Write([]byte(e.Linkname))
+		me.Size, me.SHA256 = int64(len(e.Linkname)), hex.EncodeToString(h.Sum(nil))
+	case modeChar, modeBlock:
+		me.RDevMajor, me.RDevMinor = e.RDevMajor, e.RDevMinor
+	}
+	b.entries[name] = me
+
+	if e.IsRegular() && e.NLink > 1 {
+		key := e.LinkKey()
+		group := append(b.groups[key], me)
+		b.groups[key] = group
+		// The link carrying the data gives its contents to the group.
+		src := group[0]
+		if e.FileSize > 0 {
+			src = me
+		}
+		for _, l := range group {
+			l.Size, l.SHA256 = src.Size, src.SHA256
+		}
+	}
+}
+
+func (b *manifestBuilder) manifest() *Manifest {
+	m := &Manifest{Entries: make([]ManifestEntry, 0, len(b.entries))}
+	for _, e := range b.entries {
+		m.Entries = append(m.Entries, *e)
+	}
+	slices.SortFunc(m.Entries, func(a, b ManifestEntry) int { return strings.Compare(a.Path, b.Path) })
+	return m
+}
+
+// dirManifest computes the manifest of a directory tree.
+func dirManifest(dir string) (*Manifest, error) {
+	m := &Manifest{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." || rel == ManifestName {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		st, _ := sysStat(fi)
+		me := ManifestEntry{
+			Path: rel,
+			Mode: fmt.Sprintf("%o", cpioMode(fi.Mode())),
+			UID:  st.uid,
+			GID:  st.gid,
+		}
+		h := sha256.New()
+		switch fi.Mode().Type() {
+		case 0:
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			me.Size, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			me.SHA256 = hex.EncodeToString(h.Sum(nil))
+		case fs.ModeSymlink:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			h.Write([]byte(target))
+			me.Size, me.SHA256 = int64(len(target)), hex.EncodeToString(h.Sum(nil))
+		case fs.ModeDevice, fs.ModeDevice | fs.ModeCharDevice:
+			me.RDevMajor, me.RDevMinor = st.rdevMajor, st.rdevMinor
+		}
+		m.Entries = append(m.Entries, me)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
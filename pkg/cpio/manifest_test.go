@@ -0,0 +1,179 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func manifestArchive(t *testing.T) []byte {
+	link1 := file("a", 0644, 0, "")
+	link1.Ino, link1.NLink = 7, 2
+	link2 := file("b", 0644, 0, "shared")
+	link2.Ino, link2.NLink = 7, 2
+	return buildArchive(t,
+		dir("etc", 0755, 0),
+		file("etc/passwd", 0644, 0, "root:x:0:0\n"),
+		symlink("etc/link", "passwd"),
+		link1,
+		link2,
+	)
+}
+
+func TestManifest(t *testing.T) {
+	m, err := NewManifest(bytes.NewReader(manifestArchive(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range m.Entries {
+		paths = append(paths, e.Path)
+	}
+	if got := strings.Join(paths, " "); got != "a b etc etc/link etc/passwd" {
+		t.Fatalf("paths = %s", got)
+	}
+	a, b := m.Entries[0], m.Entries[1]
+	if a.Size != 6 || a.SHA256 != b.SHA256 {
+		t.Errorf("hard links: %+v %+v", a, b)
+	}
+	if m.Entries[2].SHA256 != "" || m.Entries[2].Mode != "40755" {
+		t.Errorf("dir: %+v", m.Entries[2])
+	}
+
+	data, err := m.MarshalIndent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := ReadManifest(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := back.VerifyArchive(bytes.NewReader(manifestArchive(t)), ManifestAll); err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Reverse(back.Entries)
+	again, err := back.MarshalIndent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Errorf("reordered manifest encodes differently:\n%s", again)
+	}
+}
+
+func TestReadManifestBadMode(t *testing.T) {
+	for _, mode := range []string{"", "rw-r--r--", "100689", "400000000000"} {
+		data := `{"entries":[{"path":"etc/passwd","mode":"` + mode + `"}]}`
+		if _, err := ReadManifest(strings.NewReader(data)); err == nil {
+			t.Errorf("mode %q: no error", mode)
+		}
+	}
+}
+
+func TestManifestMismatch(t *testing.T) {
+	m, err := NewManifest(bytes.NewReader(manifestArchive(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := buildArchive(t,
+		dir("etc", 0755, 0),
+		file("etc/passwd", 0600, 0, "root::0:0\n"),
+		symlink("etc/link", "passwd"),
+		file("extra", 0644, 0, ""),
+	)
+	err = m.VerifyArchive(bytes.NewReader(tampered), ManifestAll)
+	var me *ManifestError
+	if !errors.Is(err, ErrManifestMismatch) || !errors.As(err, &me) {
+		t.Fatalf("err = %v", err)
+	}
+	want := []string{
+		"etc/passwd: mode 100600, want 100644",
+		"etc/passwd: content differs",
+		"extra: not in manifest",
+		"a: missing",
+		"b: missing",
+	}
+	if got := strings.Join(me.Problems, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("problems:\n%s", got)
+	}
+
+	// Mode changes alone pass when only contents are compared.
+	modeOnly := buildArchive(t,
+		dir("etc", 0700, 0),
+		file("etc/passwd", 0600, 0, "root:x:0:0\n"),
+		symlink("etc/link", "passwd"),
+	)
+	m.Entries = m.Entries[2:]
+	if err := m.VerifyArchive(bytes.NewReader(modeOnly), ManifestContent); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEmbedManifest(t *testing.T) {
+	var buf bytes.Buffer
+	m, err := EmbedManifest(bytes.NewReader(manifestArchive(t)), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := NewFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := fsys.ReadFile(ManifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedded, err := ReadManifest(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(embedded.Entries) != len(m.Entries) {
+		t.Fatalf("embedded %d entries, want %d", len(embedded.Entries), len(m.Entries))
+	}
+	// The manifest entry itself is not part of the manifest.
+	if err := embedded.VerifyArchive(bytes.NewReader(buf.Bytes()), ManifestAll); err != nil {
+		t.Fatal(err)
+	}
+
+	// Embedding again replaces the old manifest.
+	var again bytes.Buffer
+	if _, err := EmbedManifest(bytes.NewReader(buf.Bytes()), &again); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Bytes(), buf.Bytes()) {
+		t.Error("re-embedding changed the archive")
+	}
+}
+
+func TestManifestVerifyDir(t *testing.T) {
+	data := manifestArchive(t)
+	m, err := NewManifest(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	uo := UnpackOptions{PreserveMode: true, PreserveOwner: true}
+	if err := Unpack(bytes.NewReader(data), out, uo); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.VerifyDir(out, ManifestAll); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(out, "etc/passwd"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(out, "new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = m.VerifyDir(out, ManifestContent)
+	var me *ManifestError
+	if !errors.As(err, &me) || len(me.Problems) != 1 || me.Problems[0] != "etc/passwd: content differs" {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package cpio
+
+import "io/fs"
+
+// Unix file type and permission bits as stored in the cpio mode field.
+const (
+	modeTypeMask = 0170000
+	modeSocket   = 0140000
+	modeSymlink  = 0120000
+	modeRegular  = 0100000
+	modeBlock    = 0060000
+	modeDir      = 0040000
+	modeChar     = 0020000
+	modeFIFO     = 0010000
+
+	modeSetuid = 0004000
+	modeSetgid = 0002000
+	modeSticky = 0001000
+)
+
+// FileMode converts the cpio mode of the entry to an fs.FileMode.
+func (e *Entry) FileMode() fs.FileMode {
+	return fileMode(e.Mode)
+}
+
+// IsDir reports whether the entry is a directory.
+func (e *Entry) IsDir() bool { return e.Mode&modeTypeMask == modeDir }
+
+// IsRegular reports whether the entry is a regular file.
+func (e *Entry) IsRegular() bool { return e.Mode&modeTypeMask == modeRegular }
+
+// IsSymlink reports whether the entry is a symbolic link.
+func (e *Entry) IsSymlink() bool { return e.Mode&modeTypeMask == modeSymlink }
+
+func fileMode(mode uint32) fs.FileMode {
+	m := fs.FileMode(mode & 0777)
+	switch mode & modeTypeMask {
+	case modeDir:
+		m |= fs.ModeDir
+	case modeSymlink:
+		m |= fs.ModeSymlink
+	case modeBlock:
+		m |= fs.ModeDevice
+	case modeChar:
+		m |= fs.ModeDevice | fs.ModeCharDevice
+	case modeFIFO:
+		m |= fs.ModeNamedPipe
+	case modeSocket:
+		m |= fs.ModeSocket
+	}
+	if mode&modeSetuid != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&modeSetgid != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&modeSticky != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+// cpioMode converts an fs.FileMode to a cpio mode, including the file type.
+func cpioMode(m fs.FileMode) uint32 {
+	mode := permBits(m)
+	switch {
+	case m&fs.ModeDir != 0:
+		mode |= modeDir
+	case m&fs.ModeSymlink != 0:
+		mode |= modeSymlink
+	case m&fs.ModeCharDevice != 0:
+		mode |= modeChar
+	case m&fs.ModeDevice != 0:
+		mode |= modeBlock
+	case m&fs.ModeNamedPipe != 0:
+		mode |= modeFIFO
+	case m&fs.ModeSocket != 0:
+		mode |= modeSocket
+	default:
+		mode |= modeRegular
+	}
+	return mode
+}
+
+// permBits returns the permission, setuid, setgid and sticky bits of m.
+func permBits(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= modeSetuid
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= modeSetgid
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= modeSticky
+	}
+	return mode
+}
@@ -0,0 +1,234 @@
+package cpio
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// PackDir packs an on-disk directory into a CPIO archive (deterministic order).
+//
+// Symbolic links are stored as links, not followed. Files sharing an inode
+// are stored once as a hard link group, and device nodes, FIFOs and sockets
+// are stored as such. Pass WithGzip or WithCompression to build a compressed
+// image in one call.
+//
+// Modes are normalised to 0755 for directories and executables and 0644 for
+// other files, and ownership and mtimes come from the writer options. Use
+// PackFS(os.DirFS(root), ...) to keep them as they are on disk, and
+// WithReproducible for bit-for-bit reproducible images.
+func PackDir(root string, w io.Writer, opts ...WriterOption) error {
+	root = filepath.Clean(root)
+	p := &packer{
+		fsys: os.DirFS(root),
+		readlink: func(name string) (string, error) {
+			return os.Readlink(filepath.Join(root, filepath.FromSlash(name)))
+		},
+	}
+	return p.pack(w, opts)
+}
+
+// PackFS packs any fs.FS, such as an embed.FS, an fstest.MapFS or an FS
+// opened by NewFS, into a CPIO archive (deterministic order).
+//
+// Unlike PackDir, entries keep their exact mode, including setuid, setgid
+// and sticky bits, and their mtime. Ownership, device numbers and hard links
+// are taken from FileInfo.Sys when it is a *syscall.Stat_t or an *Entry;
+// otherwise the writer options apply. Symbolic links are stored when fsys
+// has a ReadLink method, as os.DirFS and FS do.
+func PackFS(fsys fs.FS, w io.Writer, opts ...WriterOption) error {
+	p := &packer{fsys: fsys, exact: true}
+	if rl, ok := fsys.(interface {
+		ReadLink(name string) (string, error)
+	}); ok {
+		p.readlink = rl.ReadLink
+	}
+	return p.pack(w, opts)
+}
+
+// packer walks an fs.FS into a Writer.
+type packer struct {
+	wr       *Writer
+	fsys     fs.FS
+	readlink func(name string) (string, error)
+	exact    bool // keep modes, owners and mtimes
+
+	links   map[[2]uint64]*linkGroup
+	pending []*linkGroup
+}
+
+// linkGroup collects the names of a multiply-linked file.
+type linkGroup struct {
+	names []string
+	nlink int
+	mode  fs.FileMode
+	fill  func(*Entry)
+}
+
+func (p *packer) pack(w io.Writer, opts []WriterOption) error {
+	p.wr = NewWriter(w, opts...)
+	defer p.wr.Close()
+	p.links = map[[2]uint64]*linkGroup{}
+
+	if err := fs.WalkDir(p.fsys, ".", p.add); err != nil {
+		return err
+	}
+
+	// Flush groups whose other links live outside the tree.
+	for _, g := range p.pending {
+		if len(g.names) < g.nlink {
+			if err := p.addLinkGroup(g); err != nil {
+				return err
+			}
+		}
+	}
+	return p.wr.Close()
+}
+
+func (p *packer) add(name string, d fs.DirEntry, err error) error {
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	st, hasSys := fileSys(info)
+	fill := p.fill(info, st, hasSys)
+	wr := p.wr
+
+	switch info.Mode().Type() {
+	case fs.ModeDir:
+		mode := fs.FileMode(0755)
+		if p.exact {
+			mode = info.Mode()
+		}
+		return wr.addEntry(name, modeDir|permBits(mode), nil, func(h *Entry) {
+			h.NLink = 2
+			fill(h)
+		})
+	case fs.ModeSymlink:
+		if p.readlink == nil {
+			return fmt.Errorf("cpio: %s: cannot read symlink target", name)
+		}
+		target, err := p.readlink(name)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			return fmt.Errorf("cpio: %s: empty symlink target", name)
+		}
+		return wr.addEntry(name, modeSymlink|permBits(info.Mode()), []byte(target), fill)
+	case fs.ModeDevice, fs.ModeDevice | fs.ModeCharDevice:
+		if !hasSys {
+			return fmt.Errorf("cpio: device numbers unavailable for %s", name)
+		}
+		return wr.addEntry(name, cpioMode(info.Mode()), nil, func(h *Entry) {
+			h.RDevMajor, h.RDevMinor = st.rdevMajor, st.rdevMinor
+			fill(h)
+		})
+	case fs.ModeNamedPipe:
+		return wr.addEntry(name, modeFIFO|permBits(info.Mode()), nil, fill)
+	case fs.ModeSocket:
+		return wr.addEntry(name, modeSocket|permBits(info.Mode()), nil, fill)
+	case 0:
+	default:
+		return fmt.Errorf("cpio: unsupported file type %v: %s", info.Mode().Type(), name)
+	}
+
+	mode := info.Mode()
+	if !p.exact {
+		mode = 0644
+		if info.Mode()&0100 != 0 {
+			mode = 0755
+		}
+	}
+
+	if hasSys && st.nlink > 1 {
+		key := [2]uint64{st.dev, st.ino}
+		g, ok := p.links[key]
+		if !ok {
+			g = &linkGroup{nlink: int(st.nlink), mode: mode, fill: fill}
+			p.links[key] = g
+			p.pending = append(p.pending, g)
+		}
+		g.names = append(g.names, name)
+		if len(g.names) < g.nlink {
+			return nil
+		}
+		delete(p.links, key)
+		return p.addLinkGroup(g)
+	}
+
+	f, size, err := openFile(p.fsys, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return wr.addEntryFrom(name, modeRegular|permBits(mode), size, f, fill)
+}
+
+func (p *packer) addLinkGroup(g *linkGroup) error {
+	f, size, err := openFile(p.fsys, g.names[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if len(g.names) == 1 {
+		return p.wr.addEntryFrom(g.names[0], modeRegular|permBits(g.mode), size, f, g.fill)
+	}
+	return p.wr.addHardlinkFrom(g.names, g.mode, size, f, g.fill)
+}
+
+// openFile opens a regular file of fsys and returns its size, so that its
+// contents can be streamed into the archive.
+func openFile(fsys fs.FS, name string) (fs.File, int64, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// fill returns the per-entry metadata PackFS keeps from info.
+func (p *packer) fill(info fs.FileInfo, st sysInfo, hasSys bool) func(*Entry) {
+	if !p.exact {
+		return func(*Entry) {}
+	}
+	return func(h *Entry) {
+		if hasSys {
+			h.UID, h.GID = st.uid, st.gid
+		}
+		if mt := info.ModTime(); !mt.IsZero() {
+			h.MTime = uint32(min(max(mt.Unix(), 0), math.MaxUint32))
+		}
+	}
+}
+
+// fileSys returns the ownership, device and link information of fi, taken
+// from an *Entry (files of an FS) or from the platform stat structure.
+func fileSys(fi fs.FileInfo) (sysInfo, bool) {
+	if e, ok := fi.Sys().(*Entry); ok {
+		return sysInfo{
+			dev:       uint64(e.Segment)<<40 | uint64(e.DevMajor)<<20 | uint64(e.DevMinor),
+			ino:       uint64(e.Ino),
+			nlink:     uint64(e.NLink),
+			rdevMajor: e.RDevMajor,
+			rdevMinor: e.RDevMinor,
+			uid:       e.UID,
+			gid:       e.GID,
+		}, true
+	}
+	return sysStat(fi)
+}
@@ -0,0 +1,273 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func readAll(t *testing.T, data []byte) map[string]*Entry {
+	t.Helper()
+	rd := NewReader(bytes.NewReader(data))
+	entries := map[string]*Entry{}
+	for {
+		e, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[e.Name] = e
+	}
+}
+
+func TestPackFS(t *testing.T) {
+	mtime := time.Unix(1700000000, 0)
+	src := fstest.MapFS{
+		"bin":        {Mode: fs.ModeDir | 0711, ModTime: mtime},
+		"bin/su":     {Data: []byte("su"), Mode: fs.ModeSetuid | 0755, ModTime: mtime, Sys: &Entry{UID: 0, GID: 0}},
+		"tmp":        {Mode: fs.ModeDir | fs.ModeSticky | 0777},
+		"home/user":  {Data: []byte("x"), Mode: 0600, Sys: &Entry{UID: 1000, GID: 1000}},
+		"dev/null":   {Mode: fs.ModeDevice | fs.ModeCharDevice | 0666, Sys: &Entry{RDevMajor: 1, RDevMinor: 3}},
+		"etc/a.conf": {Data: []byte("a")},
+	}
+
+	var buf bytes.Buffer
+	if err := PackFS(src, &buf, WithUIDGID(7, 7)); err != nil {
+		t.Fatal(err)
+	}
+	entries := readAll(t, buf.Bytes())
+
+	tests := []struct {
+		name     string
+		mode     fs.FileMode
+		uid, gid uint32
+	}{
+		{"bin", fs.ModeDir | 0711, 7, 7},
+		{"bin/su", fs.ModeSetuid | 0755, 0, 0},
+		{"tmp", fs.ModeDir | fs.ModeSticky | 0777, 7, 7},
+		{"home", fs.ModeDir | 0555, 7, 7},
+		{"home/user", 0600, 1000, 1000},
+		{"dev/null", fs.ModeDevice | fs.ModeCharDevice | 0666, 0, 0},
+		{"etc/a.conf", 0, 7, 7},
+	}
+	for _, tt := range tests {
+		e := entries[tt.name]
+		if e == nil {
+			t.Errorf("%s: missing", tt.name)
+			continue
+		}
+		if e.FileMode() != tt.mode || e.UID != tt.uid || e.GID != tt.gid {
+			t.Errorf("%s: got mode %v owner %d:%d, want %v %d:%d", tt.name, e.FileMode(), e.UID, e.GID, tt.mode, tt.uid, tt.gid)
+		}
+	}
+	if e := entries["bin/su"]; e.MTime != 1700000000 {
+		t.Errorf("bin/su: got mtime %d", e.MTime)
+	}
+	if e := entries["dev/null"]; e.RDevMajor != 1 || e.RDevMinor != 3 {
+		t.Errorf("dev/null: got rdev %d:%d", e.RDevMajor, e.RDevMinor)
+	}
+}
+
+func TestPackFSFromArchive(t *testing.T) {
+	data := testFSArchive(t, WithUIDGID(1000, 100), WithMTimeUnix(1700000000))
+	fsys, err := NewFS(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := PackFS(fsys, &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries := readAll(t, buf.Bytes())
+	if e := entries["bin"]; e == nil || e.Linkname != "usr/bin" || e.UID != 1000 || e.MTime != 1700000000 {
+		t.Fatalf("bin: got %+v", e)
+	}
+	a, b := entries["a"], entries["b"]
+	if a == nil || b == nil || a.Ino != b.Ino || a.NLink != 2 || a.FileSize+b.FileSize != 6 {
+		t.Fatalf("hardlinks: got %+v and %+v", a, b)
+	}
+}
+
+func TestPackSpec(t *testing.T) {
+	spec := `
+# minimal initramfs
+dir /dev 0755 0 0
+nod /dev/console 0600 0 0 c 5 1
+nod /dev/sda 0660 0 6 b 8 0
+dir /bin 0755 0 0
+file /bin/busybox /src/busybox 04755 0 0 /bin/sh
+slink /bin/ls busybox 0777 0 0
+pipe /run/initctl 0600 0 0
+sock /run/sock 0666 1000 1000
+file /init src/init 0755 0 0
+`
+	src := fstest.MapFS{
+		"src/busybox": {Data: []byte("elf")},
+		"src/init":    {Data: []byte("#!/bin/sh")},
+	}
+
+	var buf bytes.Buffer
+	if err := PackSpec(strings.NewReader(spec), src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	entries := readAll(t, buf.Bytes())
+
+	want := map[string]fs.FileMode{
+		"dev":         fs.ModeDir | 0755,
+		"dev/console": fs.ModeDevice | fs.ModeCharDevice | 0600,
+		"dev/sda":     fs.ModeDevice | 0660,
+		"bin":         fs.ModeDir | 0755,
+		"bin/busybox": fs.ModeSetuid | 0755,
+		"bin/sh":      fs.ModeSetuid | 0755,
+		"bin/ls":      fs.ModeSymlink | 0777,
+		"run/initctl": fs.ModeNamedPipe | 0600,
+		"run/sock":    fs.ModeSocket | 0666,
+		"init":        0755,
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for name, mode := range want {
+		if e := entries[name]; e == nil || e.FileMode() != mode {
+			t.Errorf("%s: got %+v, want mode %v", name, e, mode)
+		}
+	}
+	if e := entries["dev/sda"]; e.GID != 6 || e.RDevMajor != 8 {
+		t.Errorf("dev/sda: got %+v", e)
+	}
+	if e := entries["run/sock"]; e.UID != 1000 {
+		t.Errorf("run/sock: got uid %d", e.UID)
+	}
+	if e := entries["bin/ls"]; e.Linkname != "busybox" {
+		t.Errorf("bin/ls: got target %q", e.Linkname)
+	}
+	if sh, bb := entries["bin/sh"], entries["bin/busybox"]; sh.Ino != bb.Ino || sh.FileSize != 3 {
+		t.Errorf("bin/sh: got %+v", sh)
+	}
+}
+
+func TestPackSpecErrors(t *testing.T) {
+	tests := []string{
+		"bogus /x 0755 0 0",
+		"dir /x 0755 0",
+		"dir /x 0755 0 0 extra",
+		"dir /x 9755 0 0",
+		"nod /x 0600 0 0 z 1 1",
+		"file /x missing 0644 0 0",
+	}
+	for _, spec := range tests {
+		err := PackSpec(strings.NewReader(spec), fstest.MapFS{}, io.Discard)
+		if err == nil || !strings.Contains(err.Error(), "spec line 1") {
+			t.Errorf("%q: got %v", spec, err)
+		}
+	}
+}
+
+// buildTree creates the same tree in dir, creating the files in order and
+// stamping each with a different mtime.
+func buildTree(t *testing.T, dir string, order []string, mtime time.Time) {
+	t.Helper()
+	for i, name := range order {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(name, ".link") {
+			if err := os.Link(filepath.Join(dir, "etc", "data"), path); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mt := mtime.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(path, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPackReproducible(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	// The link target comes first, the rest is created in reverse order.
+	orders := [2][]string{
+		{"etc/data", "etc/hosts", "bin/a.link", "usr/lib/b.link", "init"},
+		{"etc/data", "init", "usr/lib/b.link", "bin/a.link", "etc/hosts"},
+	}
+
+	var images [2][]byte
+	for i, order := range orders {
+		dir := t.TempDir()
+		buildTree(t, dir, order, time.Unix(1800000000-int64(i)*1e6, 0))
+
+		var buf bytes.Buffer
+		if err := PackFS(os.DirFS(dir), &buf, WithReproducible(0, 0)); err != nil {
+			t.Fatal(err)
+		}
+		images[i] = buf.Bytes()
+	}
+	if !bytes.Equal(images[0], images[1]) {
+		t.Fatal("builds differ")
+	}
+
+	entries := readAll(t, images[0])
+	for name, e := range entries {
+		if e.MTime != 1700000000 || e.UID != 0 || e.GID != 0 || e.DevMajor != 0 {
+			t.Errorf("%s: got %+v", name, e)
+		}
+	}
+	a, b, d := entries["bin/a.link"], entries["usr/lib/b.link"], entries["etc/data"]
+	if a.Ino != b.Ino || a.Ino != d.Ino || a.NLink != 3 {
+		t.Errorf("hardlinks: got inodes %d, %d, %d", a.Ino, b.Ino, d.Ino)
+	}
+}
+
+func TestWriterStableInodes(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithStableInodes(), WithClampMTime(100), WithOwner(5, 6))
+	hdrs := []*Entry{
+		{Name: "a", Ino: 900, Mode: modeRegular | 0644, NLink: 2, DevMajor: 8, MTime: 50},
+		{Name: "dir", Ino: 17, Mode: modeDir | 0755, NLink: 2, DevMajor: 8, MTime: 500},
+		{Name: "b", Ino: 900, Mode: modeRegular | 0644, NLink: 2, DevMajor: 8, UID: 1000},
+		{Name: "c", Ino: 900, Mode: modeRegular | 0644, NLink: 1, DevMajor: 9},
+	}
+	for _, h := range hdrs {
+		if err := wr.WriteEntry(h, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wr.AddFile("d", 0644, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := readAll(t, buf.Bytes())
+	want := map[string]struct{ ino, mtime uint32 }{
+		"a": {1, 50}, "dir": {2, 100}, "b": {1, 0}, "c": {3, 0}, "d": {4, 0},
+	}
+	for name, w := range want {
+		e := entries[name]
+		if e.Ino != w.ino || e.MTime != w.mtime || e.DevMajor != 0 || e.UID != 5 || e.GID != 6 {
+			t.Errorf("%s: got %+v, want ino %d mtime %d", name, e, w.ino, w.mtime)
+		}
+	}
+}
+
+func TestSourceDateEpochInvalid(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	wr := NewWriter(io.Discard, WithSourceDateEpoch())
+	if err := wr.AddDir("etc", 0755); err == nil || !strings.Contains(err.Error(), "SOURCE_DATE_EPOCH") {
+		t.Fatalf("got %v", err)
+	}
+}
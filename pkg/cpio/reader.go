@@ -1,7 +1,10 @@
 package cpio
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 )
 
@@ -18,13 +21,30 @@ func parseHex8(b []byte) (uint32, error) {
 		case c >= 'a' && c <= 'f':
 			n = uint32(c-'a') + 10
 		default:
-			return 0, errors.New("cpio: invalid hex")
+			return 0, fmt.Errorf("%w: invalid hex", ErrInvalidHeader)
 		}
 		v = (v << 4) | n
 	}
 	return v, nil
 }
 
+// Field indexes of the newc header, after the magic.
+const (
+	hIno = iota
+	hMode
+	hUID
+	hGID
+	hNLink
+	hMTime
+	hFileSize
+	hDevMajor
+	hDevMinor
+	hRDevMajor
+	hRDevMinor
+	hNameSize
+	hCheck
+)
+
 func pad4(n uint32) uint32 {
 	rem := n & 3
 	if rem == 0 {
@@ -33,120 +53,274 @@ func pad4(n uint32) uint32 {
 	return 4 - rem
 }
 
-func discardN(r io.Reader, n uint32) error {
+func discardN(r io.Reader, n int64) error {
 	if n == 0 {
 		return nil
 	}
-	buf := make([]byte, 128)
-	for n > 0 {
-		chunk := uint32(len(buf))
-		if chunk > n {
-			chunk = n
-		}
-		_, err := io.ReadFull(r, buf[:chunk])
-		if err != nil {
-			return err
-		}
-		n -= chunk
-	}
-	return nil
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
 }
 
-// Next returns the next entry. When the archive ends, it returns io.EOF.
+// Next advances to the next entry and returns its header. Any unread body
+// bytes of the previous entry are skipped. When the archive ends, it returns
+// io.EOF. With WithConcatenated, reading continues past each trailer until
+// the end of the stream.
 func (rd *Reader) Next() (*Entry, error) {
 	if rd == nil || rd.done {
 		return nil, io.EOF
 	}
 
-	var hdr [110]byte
-	n, err := io.ReadFull(rd.r, hdr[:])
-	if err != nil {
-		// If we didn't read anything, treat as clean EOF.
-		if errors.Is(err, io.EOF) && n == 0 {
+	if rd.crc && rd.remain > 0 {
+		// Skip through Read so the checksum is still verified.
+		if _, err := io.Copy(io.Discard, rd); err != nil {
+			return nil, err
+		}
+	}
+	if err := discardN(rd.r, int64(rd.remain)+int64(rd.pad)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	rd.remain, rd.pad = 0, 0
+
+	for {
+		if rd.atSegment {
+			if err := rd.startSegment(); err != nil {
+				if errors.Is(err, io.EOF) {
+					rd.done = true
+				}
+				return nil, err
+			}
+			rd.atSegment = false
+		}
+		e, err := rd.next()
+		if !errors.Is(err, errTrailer) {
+			return e, err
+		}
+		if !rd.multi {
 			rd.done = true
 			return nil, io.EOF
 		}
-		return nil, err
+		rd.segment++
+		rd.atSegment = true
 	}
+}
+
+// errTrailer is returned by next at the TRAILER!!! entry of a segment.
+var errTrailer = errors.New("cpio: trailer")
 
-	magic := string(hdr[0:6])
-	if magic != magicNewc && magic != magicCrc {
-		return nil, errors.New("cpio: invalid magic")
+// startSegment prepares the stream for the next archive. In concatenated
+// mode it skips the zero padding before it, and with decompression it
+// stacks a decompressor if the archive is compressed.
+func (rd *Reader) startSegment() error {
+	for {
+		if rd.multi {
+			if err := rd.skipPadding(); err != nil {
+				return err
+			}
+		}
+		if !rd.decompress {
+			return nil
+		}
+		pushed, err := rd.sniff()
+		if err != nil || !pushed {
+			return err
+		}
 	}
+}
 
-	mode, err := parseHex8(hdr[14:22])
-	if err != nil {
-		return nil, err
+// skipPadding skips the zero bytes between concatenated archives. When a
+// decompressed stream ends, reading resumes from the stream below it.
+func (rd *Reader) skipPadding() error {
+	for {
+		b, err := rd.br.ReadByte()
+		if errors.Is(err, io.EOF) && rd.pop() {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if b != 0 {
+			return rd.br.UnreadByte()
+		}
+		rd.r.n++
+		if err := rd.checkArchiveSize(0); err != nil {
+			return err
+		}
 	}
-	uid, err := parseHex8(hdr[22:30])
+}
+
+// next reads the next header of the current segment.
+func (rd *Reader) next() (*Entry, error) {
+	e, namesize, err := rd.readHeader()
 	if err != nil {
 		return nil, err
 	}
-	gid, err := parseHex8(hdr[30:38])
-	if err != nil {
-		return nil, err
+	filesize, hsize := e.FileSize, e.Format.headerSize()
+
+	if namesize == 0 {
+		return nil, fmt.Errorf("%w: invalid namesize", ErrInvalidHeader)
 	}
-	nlink, err := parseHex8(hdr[38:46])
-	if err != nil {
-		return nil, err
+	if rd.maxName > 0 && namesize > rd.maxName {
+		return nil, fmt.Errorf("%w: %d bytes", ErrNameTooLong, namesize)
 	}
-	mtime, err := parseHex8(hdr[46:54])
-	if err != nil {
+	if err := rd.checkArchiveSize(int64(namesize) + int64(e.Format.pad(hsize+namesize))); err != nil {
 		return nil, err
 	}
-	filesize, err := parseHex8(hdr[54:62])
-	if err != nil {
-		return nil, err
+
+	nameb := make([]byte, namesize)
+	if _, err := io.ReadFull(rd.r, nameb); err != nil {
+		return nil, unexpectedEOF(err)
 	}
-	namesize, err := parseHex8(hdr[94:102])
-	if err != nil {
-		return nil, err
+	if nameb[namesize-1] != 0 {
+		return nil, fmt.Errorf("%w: name not NUL-terminated", ErrInvalidHeader)
 	}
+	name := string(nameb[:bytes.IndexByte(nameb, 0)])
+	e.Name = name
 
-	if namesize == 0 {
-		return nil, errors.New("cpio: invalid namesize")
+	// Align after header + name.
+	if err := discardN(rd.r, int64(e.Format.pad(hsize+namesize))); err != nil {
+		return nil, unexpectedEOF(err)
 	}
 
-	nameb := make([]byte, namesize)
-	if _, err := io.ReadFull(rd.r, nameb); err != nil {
+	if name == "TRAILER!!!" {
+		return nil, errTrailer
+	}
+	e.Segment = rd.segment
+
+	rd.entries++
+	if rd.maxEntries > 0 && rd.entries > rd.maxEntries {
+		return nil, fmt.Errorf("%w: more than %d", ErrTooManyEntries, rd.maxEntries)
+	}
+	if rd.maxEntry > 0 && int64(filesize) > rd.maxEntry {
+		return nil, fmt.Errorf("%w: %q is %d bytes", ErrEntryTooLarge, name, filesize)
+	}
+	if err := rd.checkArchiveSize(int64(filesize) + int64(e.Format.pad(filesize))); err != nil {
 		return nil, err
 	}
-	name := ""
-	for i := 0; i < len(nameb); i++ {
-		if nameb[i] == 0 {
-			break
+
+	rd.remain = filesize
+	rd.pad = e.Format.pad(filesize)
+	rd.crc = rd.verifyCRC && e.Format == FormatCRC
+	rd.sum, rd.name, rd.check = 0, name, e.Check
+	if rd.crc && filesize == 0 && e.Check != 0 {
+		return nil, &ChecksumError{Name: name, Want: e.Check}
+	}
+
+	if e.IsSymlink() {
+		if rd.maxName > 0 && filesize > rd.maxName {
+			return nil, fmt.Errorf("%w: link target of %q is %d bytes", ErrNameTooLong, name, filesize)
+		}
+		target, err := io.ReadAll(rd)
+		if err != nil {
+			return nil, err
 		}
-		name += string([]byte{nameb[i]})
+		e.Linkname = string(target)
 	}
+	return e, nil
+}
 
-	// Align after header + name.
-	if err := discardN(rd.r, pad4(110+namesize)); err != nil {
-		return nil, err
+// readHeader reads the fixed part of the next header, detecting its format
+// from the magic. It returns the entry without its name and the name size.
+func (rd *Reader) readHeader() (*Entry, uint32, error) {
+	var hdr [110]byte
+	n, err := io.ReadFull(rd.r, hdr[:2])
+	if err != nil {
+		// If we didn't read anything, treat as clean EOF.
+		if errors.Is(err, io.EOF) && n == 0 {
+			rd.done = true
+			return nil, 0, io.EOF
+		}
+		return nil, 0, err
 	}
 
-	if name == "TRAILER!!!" {
-		rd.done = true
-		return nil, io.EOF
+	var (
+		e        *Entry
+		namesize uint32
+		format   Format
+	)
+	switch {
+	case binary.LittleEndian.Uint16(hdr[:2]) == magicBinary:
+		format = FormatBinaryLE
+	case binary.BigEndian.Uint16(hdr[:2]) == magicBinary:
+		format = FormatBinaryBE
+	default:
+		if _, err := io.ReadFull(rd.r, hdr[2:6]); err != nil {
+			return nil, 0, unexpectedEOF(err)
+		}
+		switch string(hdr[:6]) {
+		case magicNewc:
+			format = FormatNewc
+		case magicCrc:
+			format = FormatCRC
+		case magicODC:
+			format = FormatODC
+		default:
+			return nil, 0, fmt.Errorf("%w: invalid magic", ErrInvalidHeader)
+		}
 	}
 
-	data := make([]byte, filesize)
-	if filesize > 0 {
-		if _, err := io.ReadFull(rd.r, data); err != nil {
-			return nil, err
+	hsize := format.headerSize()
+	start := uint32(6)
+	if format == FormatBinaryLE || format == FormatBinaryBE {
+		start = 2
+	}
+	if _, err := io.ReadFull(rd.r, hdr[start:hsize]); err != nil {
+		return nil, 0, unexpectedEOF(err)
+	}
+
+	switch format {
+	case FormatNewc, FormatCRC:
+		e, namesize, err = parseNewcHeader(hdr[6:hsize])
+	case FormatODC:
+		e, namesize, err = parseODCHeader(hdr[6:hsize])
+	case FormatBinaryLE:
+		e, namesize, err = parseBinaryHeader(hdr[:hsize], binary.LittleEndian)
+	case FormatBinaryBE:
+		e, namesize, err = parseBinaryHeader(hdr[:hsize], binary.BigEndian)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	e.Format = format
+	return e, namesize, nil
+}
+
+// checkArchiveSize reports whether n more bytes fit in the archive limit.
+func (rd *Reader) checkArchiveSize(n int64) error {
+	if rd.maxArchive > 0 && rd.r.n+n > rd.maxArchive {
+		return fmt.Errorf("%w: exceeds %d bytes", ErrArchiveTooLarge, rd.maxArchive)
+	}
+	return nil
+}
+
+// Read reads from the body of the current entry. It returns io.EOF at the
+// end of the body, or before the first call to Next. With WithVerifyCRC, the
+// read that completes the body returns a *ChecksumError on mismatch.
+func (rd *Reader) Read(p []byte) (int, error) {
+	if rd == nil || rd.remain == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > int64(rd.remain) {
+		p = p[:rd.remain]
+	}
+	n, err := rd.r.Read(p)
+	rd.remain -= uint32(n)
+	if rd.crc {
+		rd.sum = checksum(rd.sum, p[:n])
+		if rd.remain == 0 && rd.sum != rd.check {
+			return n, &ChecksumError{Name: rd.name, Want: rd.check, Got: rd.sum}
 		}
 	}
-	if err := discardN(rd.r, pad4(filesize)); err != nil {
-		return nil, err
+	if errors.Is(err, io.EOF) && rd.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	} else if errors.Is(err, io.EOF) {
+		err = nil
 	}
+	return n, err
+}
 
-	return &Entry{
-		Name:     name,
-		Mode:     mode,
-		UID:      uid,
-		GID:      gid,
-		NLink:    nlink,
-		MTime:    mtime,
-		FileSize: filesize,
-		Data:     data,
-	}, nil
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
 }
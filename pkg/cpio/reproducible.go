@@ -0,0 +1,97 @@
+package cpio
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+// WithClampMTime limits the mtime of every entry, including those written
+// with WriteEntry, to mtime.
+func WithClampMTime(mtime uint32) WriterOption {
+	return func(w *Writer) {
+		w.clamp = true
+		w.clampMTime = mtime
+	}
+}
+
+// WithSourceDateEpoch clamps mtimes to the SOURCE_DATE_EPOCH environment
+// variable, as specified by reproducible-builds.org. It has no effect when
+// the variable is unset; a malformed value makes every write fail.
+func WithSourceDateEpoch() WriterOption {
+	return func(w *Writer) {
+		v, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+		if !ok || v == "" {
+			return
+		}
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			w.err = fmt.Errorf("cpio: invalid SOURCE_DATE_EPOCH %q", v)
+			return
+		}
+		w.clamp = true
+		w.clampMTime = uint32(min(n, math.MaxUint32))
+	}
+}
+
+// WithStableInodes renumbers inodes sequentially in the order entries are
+// written, and clears the device numbers of the entries. Hard links keep
+// sharing one inode, so the output does not depend on the inode numbers of
+// the source.
+func WithStableInodes() WriterOption { return func(w *Writer) { w.stable = true } }
+
+// WithOwner sets the owner of every entry, including those written with
+// WriteEntry and those PackFS takes from the source.
+func WithOwner(uid, gid uint32) WriterOption {
+	return func(w *Writer) {
+		w.force = true
+		w.uid, w.gid = uid, gid
+	}
+}
+
+// WithReproducible combines WithSourceDateEpoch, WithStableInodes and
+// WithOwner, so that the same content always produces the same archive,
+// whatever the order, timestamps and ownership of the source files.
+func WithReproducible(uid, gid uint32) WriterOption {
+	return func(w *Writer) {
+		WithSourceDateEpoch()(w)
+		WithStableInodes()(w)
+		WithOwner(uid, gid)(w)
+	}
+}
+
+// linkID identifies the inode shared by hard links written to a Writer.
+type linkID struct{ ino, devMajor, devMinor uint32 }
+
+// normalize applies the reproducible-build options to h.
+func (wr *Writer) normalize(h *Entry) {
+	if h.IsTrailer() {
+		return
+	}
+	if wr.clamp && h.MTime > wr.clampMTime {
+		h.MTime = wr.clampMTime
+	}
+	if wr.force {
+		h.UID, h.GID = wr.uid, wr.gid
+	}
+	if !wr.stable {
+		return
+	}
+	id := linkID{h.Ino, h.DevMajor, h.DevMinor}
+	h.DevMajor, h.DevMinor = 0, 0
+	if h.NLink > 1 && !h.IsDir() {
+		if ino, ok := wr.inodes[id]; ok {
+			h.Ino = ino
+			return
+		}
+	}
+	wr.nextIno++
+	if h.NLink > 1 && !h.IsDir() {
+		if wr.inodes == nil {
+			wr.inodes = map[linkID]uint32{}
+		}
+		wr.inodes[id] = wr.nextIno
+	}
+	h.Ino = wr.nextIno
+}
@@ -0,0 +1,151 @@
+package cpio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PackSpec builds an archive from a description in the format of the Linux
+// kernel's usr/gen_init_cpio:
+//
+//	# comment
+//	file <name> <location> <mode> <uid> <gid> [<hard links>...]
+//	dir <name> <mode> <uid> <gid>
+//	nod <name> <mode> <uid> <gid> <dev_type> <maj> <min>
+//	slink <name> <target> <mode> <uid> <gid>
+//	pipe <name> <mode> <uid> <gid>
+//	sock <name> <mode> <uid> <gid>
+//
+// Modes are octal and dev_type is "c" or "b". Entries are written in spec
+// order with exactly the given modes, ownership and device numbers, so no
+// root privileges are needed. File contents are read from location in src,
+// or from the local filesystem when src is nil. Mtimes come from the writer
+// options.
+func PackSpec(spec io.Reader, src fs.FS, w io.Writer, opts ...WriterOption) error {
+	wr := NewWriter(w, opts...)
+	defer wr.Close()
+
+	sc := bufio.NewScanner(spec)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if err := packSpecLine(wr, src, fields); err != nil {
+			return fmt.Errorf("cpio: spec line %d: %w", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return wr.Close()
+}
+
+// specArgs is the number of arguments of each spec line type, after the
+// type itself.
+var specArgs = map[string]int{
+	"file":  5,
+	"dir":   4,
+	"nod":   7,
+	"slink": 5,
+	"pipe":  4,
+	"sock":  4,
+}
+
+func packSpecLine(wr *Writer, src fs.FS, fields []string) error {
+	typ, args := fields[0], fields[1:]
+	n, ok := specArgs[typ]
+	if !ok {
+		return fmt.Errorf("unknown type %q", typ)
+	}
+	if len(args) < n || (len(args) > n && typ != "file") {
+		return fmt.Errorf("%s: got %d arguments, want %d", typ, len(args), n)
+	}
+
+	// Every type has <mode> <uid> <gid> after its name and location/target.
+	at := 1
+	if typ == "file" || typ == "slink" {
+		at = 2
+	}
+	mode, err := strconv.ParseUint(args[at], 8, 32)
+	if err != nil || mode > 07777 {
+		return fmt.Errorf("%s: invalid mode %q", typ, args[at])
+	}
+	uid, err := strconv.ParseUint(args[at+1], 10, 32)
+	if err != nil {
+		return fmt.Errorf("%s: invalid uid %q", typ, args[at+1])
+	}
+	gid, err := strconv.ParseUint(args[at+2], 10, 32)
+	if err != nil {
+		return fmt.Errorf("%s: invalid gid %q", typ, args[at+2])
+	}
+	owner := func(h *Entry) { h.UID, h.GID = uint32(uid), uint32(gid) }
+	name, perm := args[0], uint32(mode)
+
+	switch typ {
+	case "file":
+		f, size, err := openSpecFile(src, args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if links := args[n:]; len(links) > 0 {
+			return wr.addHardlinkFrom(append([]string{name}, links...), fileMode(perm), size, f, owner)
+		}
+		return wr.addEntryFrom(name, modeRegular|perm, size, f, owner)
+	case "dir":
+		return wr.addEntry(name, modeDir|perm, nil, func(h *Entry) {
+			h.NLink = 2
+			owner(h)
+		})
+	case "nod":
+		var typeBits uint32
+		switch args[4] {
+		case "c":
+			typeBits = modeChar
+		case "b":
+			typeBits = modeBlock
+		default:
+			return fmt.Errorf("nod: invalid device type %q", args[4])
+		}
+		major, err := strconv.ParseUint(args[5], 10, 32)
+		if err != nil {
+			return fmt.Errorf("nod: invalid major %q", args[5])
+		}
+		minor, err := strconv.ParseUint(args[6], 10, 32)
+		if err != nil {
+			return fmt.Errorf("nod: invalid minor %q", args[6])
+		}
+		return wr.addEntry(name, typeBits|perm, nil, func(h *Entry) {
+			h.RDevMajor, h.RDevMinor = uint32(major), uint32(minor)
+			owner(h)
+		})
+	case "slink":
+		return wr.addEntry(name, modeSymlink|perm, []byte(args[1]), owner)
+	case "pipe":
+		return wr.addEntry(name, modeFIFO|perm, nil, owner)
+	default: // sock
+		return wr.addEntry(name, modeSocket|perm, nil, owner)
+	}
+}
+
+func openSpecFile(src fs.FS, location string) (fs.File, int64, error) {
+	if src == nil {
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, 0, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		return f, fi.Size(), nil
+	}
+	return openFile(src, strings.TrimPrefix(location, "/"))
+}
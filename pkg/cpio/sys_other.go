@@ -0,0 +1,18 @@
+//go:build !unix
+
+package cpio
+
+import "io/fs"
+
+// sysInfo holds the stat fields the packers need.
+type sysInfo struct {
+	dev       uint64
+	ino       uint64
+	nlink     uint64
+	rdevMajor uint32
+	rdevMinor uint32
+	uid       uint32
+	gid       uint32
+}
+
+func sysStat(fi fs.FileInfo) (sysInfo, bool) { return sysInfo{}, false }
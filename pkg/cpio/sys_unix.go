@@ -0,0 +1,35 @@
+//go:build unix
+
+package cpio
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// sysInfo holds the stat fields the packers need.
+type sysInfo struct {
+	dev       uint64
+	ino       uint64
+	nlink     uint64
+	rdevMajor uint32
+	rdevMinor uint32
+	uid       uint32
+	gid       uint32
+}
+
+func sysStat(fi fs.FileInfo) (sysInfo, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return sysInfo{}, false
+	}
+	return sysInfo{
+		dev:       uint64(st.Dev),
+		ino:       uint64(st.Ino),
+		nlink:     uint64(st.Nlink),
+		rdevMajor: devMajor(uint64(st.Rdev)),
+		rdevMinor: devMinor(uint64(st.Rdev)),
+		uid:       st.Uid,
+		gid:       st.Gid,
+	}, true
+}
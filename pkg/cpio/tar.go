@@ -0,0 +1,387 @@
+package cpio
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Warning reports information dropped while converting an entry.
+type Warning struct {
+	Name string
+	Msg  string
+}
+
+func (w Warning) String() string { return w.Name + ": " + w.Msg }
+
+// ToTar converts the cpio archive read from r to a tar archive written to
+// w. Reader options apply to the input.
+//
+// Modes, ownership, mtimes, symbolic links, device nodes and FIFOs are
+// mapped to the equivalent tar headers, and hard link groups become a
+// regular file followed by links to it. Inode and device numbers are not
+// carried. Device numbers of entries other than device nodes and the
+// checksums of crc archives are dropped with a warning. Sockets cannot be
+// stored in tar: they are skipped with a warning.
+func ToTar(r io.Reader, w io.Writer, opts ...ReaderOption) ([]Warning, error) {
+	rd := NewReader(r, opts...)
+	tw := tar.NewWriter(w)
+	c := &tarConverter{tw: tw, groups: map[LinkKey]*tarGroup{}}
+	for {
+		e, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return c.warnings, err
+		}
+		if err := c.add(e, rd); err != nil {
+			return c.warnings, err
+		}
+	}
+	// Groups whose data never came are written as empty files.
+	for _, key := range c.order {
+		if g := c.groups[key]; g.target == "" {
+			if err := c.flush(g, nil); err != nil {
+				return c.warnings, err
+			}
+		}
+	}
+	return c.warnings, tw.Close()
+}
+
+type tarConverter struct {
+	tw       *tar.Writer
+	groups   map[LinkKey]*tarGroup
+	order    []LinkKey
+	warnings []Warning
+}
+
+// tarGroup is a cpio hard link group. Links seen before the one carrying
+// the data are held back until it arrives, since tar links must follow
+// the file they point to.
+type tarGroup struct {
+	target  string
+	pending []*Entry
+}
+
+func (c *tarConverter) warn(name, format string, args ...any) {
+	c.warnings = append(c.warnings, Warning{Name: name, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (c *tarConverter) add(e *Entry, body io.Reader) error {
+	if e.Check != 0 || e.Format == FormatCRC && e.IsRegular() {
+		c.warn(e.Name, "crc checksum dropped")
+	}
+	if typ := e.Mode & modeTypeMask; typ != modeChar && typ != modeBlock && (e.RDevMajor != 0 || e.RDevMinor != 0) {
+		c.warn(e.Name, "device number %d, %d dropped", e.RDevMajor, e.RDevMinor)
+	}
+	if e.IsRegular() && e.NLink > 1 {
+		key := e.LinkKey()
+		g, ok := c.groups[key]
+		if !ok {
+			g = &tarGroup{}
+			c.groups[key] = g
+			c.order = append(c.order, key)
+		}
+		if g.target != "" {
+			if e.FileSize > 0 {
+				c.warn(e.Name, "second copy of hard link data dropped")
+			}
+			return c.tw.WriteHeader(tarHeader(e, tar.TypeLink, g.target))
+		}
+		g.pending = append(g.pending, e)
+		if e.FileSize > 0 || len(g.pending) == int(e.NLink) {
+			return c.flush(g, body)
+		}
+		return nil
+	}
+
+	switch e.Mode & modeTypeMask {
+	case modeRegular:
+		return c.writeFile(e, body)
+	case modeDir:
+		h := tarHeader(e, tar.TypeDir, "")
+		h.Name = strings.TrimSuffix(h.Name, "/") + "/"
+		return c.tw.WriteHeader(h)
+	case modeSymlink:
+		return c.tw.WriteHeader(tarHeader(e, tar.TypeSymlink, e.Linkname))
+	case modeChar:
+		return c.tw.WriteHeader(tarHeader(e, tar.TypeChar, ""))
+	case modeBlock:
+		return c.tw.WriteHeader(tarHeader(e, tar.TypeBlock, ""))
+	case modeFIFO:
+		return c.tw.WriteHeader(tarHeader(e, tar.TypeFifo, ""))
+	case modeSocket:
+		c.warn(e.Name, "socket skipped, tar cannot store sockets")
+		return nil
+	default:
+		return fmt.Errorf("cpio: unsupported file type %o: %s", e.Mode&modeTypeMask, e.Name)
+	}
+}
+
+// flush writes the last pending link of g with the group data, then the
+// others as links to it.
+func (c *tarConverter) flush(g *tarGroup, body io.Reader) error {
+	last := g.pending[len(g.pending)-1]
+	if err := c.writeFile(last, body); err != nil {
+		return err
+	}
+	g.target = last.Name
+	for _, e := range g.pending[:len(g.pending)-1] {
+		if err := c.tw.WriteHeader(tarHeader(e, tar.TypeLink, g.target)); err != nil {
+			return err
+		}
+	}
+	g.pending = nil
+	return nil
+}
+
+func (c *tarConverter) writeFile(e *Entry, body io.Reader) error {
+	h := tarHeader(e, tar.TypeReg, "")
+	h.Size = int64(e.FileSize)
+	if err := c.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	if h.Size == 0 {
+		return nil
+	}
+	_, err := io.CopyN(c.tw, body, h.Size)
+	return err
+}
+
+func tarHeader(e *Entry, typ byte, linkname string) *tar.Header {
+	h := &tar.Header{
+		Typeflag: typ,
+		Name:     e.Name,
+		Linkname: linkname,
+		Mode:     int64(e.Mode &^ modeTypeMask),
+		Uid:      int(e.UID),
+		Gid:      int(e.GID),
+		ModTime:  time.Unix(int64(e.MTime), 0),
+	}
+	if typ == tar.TypeChar || typ == tar.TypeBlock {
+		h.Devmajor, h.Devminor = int64(e.RDevMajor), int64(e.RDevMinor)
+	}
+	return h
+}
+
+// FromTar converts the tar archive read from r to a cpio archive written
+// to w. Writer options select the output format.
+//
+// Regular files, directories, symbolic links, hard links, device nodes and
+// FIFOs are converted with their modes, ownership and mtimes. User and
+// group names, access and change times, other PAX records, such as
+// extended attributes, and sub-second mtimes are dropped with a warning.
+// Global headers are skipped with a warning.
+//
+// Values that do not fit the cpio header are reported with
+// ErrFieldOverflow, and entries cpio cannot store with ErrUnrepresentable.
+// A cpio hard link group must know its size up front, so hard links are
+// only converted when r is an io.ReadSeeker: the headers are then read
+// once to find the groups before converting.
+func FromTar(r io.Reader, w io.Writer, opts ...WriterOption) ([]Warning, error) {
+	c := &cpioConverter{wr: NewWriter(w, opts...)}
+	if rs, ok := r.(io.ReadSeeker); ok {
+		links, err := scanTarLinks(rs)
+		if err != nil {
+			return nil, err
+		}
+		c.links = links
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return c.warnings, err
+		}
+		if err := c.add(h, tr); err != nil {
+			return c.warnings, err
+		}
+	}
+	return c.warnings, c.wr.Close()
+}
+
+// tarLinks describes the hard link groups of a tar archive, keyed by the
+// name of the file the links point to.
+type tarLinks struct {
+	nlink map[string]uint32
+	ino   map[string]uint32
+}
+
+// scanTarLinks reads the headers of the tar archive in rs and rewinds it.
+func scanTarLinks(rs io.ReadSeeker) (*tarLinks, error) {
+	pos, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	links := &tarLinks{nlink: map[string]uint32{}, ino: map[string]uint32{}}
+	alias := map[string]string{} // link name -> file it resolves to
+	tr := tar.NewReader(rs)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeLink {
+			continue
+		}
+		target := h.Linkname
+		if t, ok := alias[target]; ok {
+			target = t
+		}
+		alias[h.Name] = target
+		if links.nlink[target] == 0 {
+			links.nlink[target] = 1
+		}
+		links.nlink[target]++
+	}
+	_, err = rs.Seek(pos, io.SeekStart)
+	return links, err
+}
+
+type cpioConverter struct {
+	wr       *Writer
+	links    *tarLinks
+	alias    map[string]string
+	warnings []Warning
+}
+
+func (c *cpioConverter) warn(name, format string, args ...any) {
+	c.warnings = append(c.warnings, Warning{Name: name, Msg: fmt.Sprintf(format, args...)})
+}
+
+// tarKnownPAX lists the PAX records FromTar maps, or reports through the
+// header fields they set.
+var tarKnownPAX = map[string]bool{
+	"path": true, "linkpath": true, "size": true, "uid": true, "gid": true,
+	"uname": true, "gname": true, "mtime": true, "atime": true, "ctime": true,
+}
+
+func (c *cpioConverter) add(h *tar.Header, body io.Reader) error {
+	if h.Typeflag == tar.TypeXGlobalHeader {
+		c.warn(h.Name, "global header skipped")
+		return nil
+	}
+
+	e, err := cpioEntry(h)
+	if err != nil {
+		return err
+	}
+	e.Ino = c.wr.ino
+	for _, k := range slices.Sorted(maps.Keys(h.PAXRecords)) {
+		if !tarKnownPAX[k] {
+			c.warn(h.Name, "PAX record %s dropped", k)
+		}
+	}
+	if h.Uname != "" {
+		c.warn(h.Name, "user name %q dropped", h.Uname)
+	}
+	if h.Gname != "" {
+		c.warn(h.Name, "group name %q dropped", h.Gname)
+	}
+	if !h.AccessTime.IsZero() {
+		c.warn(h.Name, "access time dropped")
+	}
+	if !h.ChangeTime.IsZero() {
+		c.warn(h.Name, "change time dropped")
+	}
+	if h.ModTime.Nanosecond() != 0 {
+		c.warn(h.Name, "sub-second mtime truncated")
+	}
+
+	switch h.Typeflag {
+	case tar.TypeReg:
+		e.Mode |= modeRegular
+		if n := c.nlink(e.Name); n > 1 {
+			e.NLink = n
+			c.links.ino[e.Name] = e.Ino
+		}
+	case tar.TypeLink:
+		if c.links == nil {
+			return fmt.Errorf("%w: %s: hard link in a non-seekable tar stream", ErrUnrepresentable, h.Name)
+		}
+		target := h.Linkname
+		if t, ok := c.alias[target]; ok {
+			target = t
+		}
+		ino, ok := c.links.ino[target]
+		if !ok {
+			return fmt.Errorf("%w: %s: hard link to unknown file %s", ErrUnrepresentable, h.Name, h.Linkname)
+		}
+		if c.alias == nil {
+			c.alias = map[string]string{}
+		}
+		c.alias[h.Name] = target
+		e.Mode |= modeRegular
+		e.Ino, e.NLink, e.FileSize = ino, c.links.nlink[target], 0
+		return c.wr.WriteEntry(e, nil)
+	case tar.TypeDir:
+		e.Mode |= modeDir
+		e.NLink = 2
+	case tar.TypeSymlink:
+		e.Mode |= modeSymlink
+		e.Linkname = h.Linkname
+	case tar.TypeChar, tar.TypeBlock:
+		if h.Devmajor < 0 || h.Devmajor > math.MaxUint32 || h.Devminor < 0 || h.Devminor > math.MaxUint32 {
+			return fmt.Errorf("%w: %s: device %d:%d", ErrFieldOverflow, h.Name, h.Devmajor, h.Devminor)
+		}
+		e.Mode |= modeChar
+		if h.Typeflag == tar.TypeBlock {
+			e.Mode = e.Mode&^modeTypeMask | modeBlock
+		}
+		e.RDevMajor, e.RDevMinor = uint32(h.Devmajor), uint32(h.Devminor)
+	case tar.TypeFifo:
+		e.Mode |= modeFIFO
+	default:
+		return fmt.Errorf("%w: %s: tar type %q", ErrUnrepresentable, h.Name, h.Typeflag)
+	}
+	return c.wr.WriteEntry(e, body)
+}
+
+// nlink returns the link count of the file name, which is 1 unless hard
+// links point to it.
+func (c *cpioConverter) nlink(name string) uint32 {
+	if c.links == nil || c.links.nlink[name] == 0 {
+		return 1
+	}
+	return c.links.nlink[name]
+}
+
+// cpioEntry converts the fields common to every tar header type.
+func cpioEntry(h *tar.Header) (*Entry, error) {
+	mtime := h.ModTime.Unix()
+	switch {
+	case h.Size < 0 || h.Size > MaxFileSize:
+		return nil, fmt.Errorf("%w: %s: size %d", ErrFieldOverflow, h.Name, h.Size)
+	case h.Uid < 0 || int64(h.Uid) > math.MaxUint32 || h.Gid < 0 || int64(h.Gid) > math.MaxUint32:
+		return nil, fmt.Errorf("%w: %s: owner %d:%d", ErrFieldOverflow, h.Name, h.Uid, h.Gid)
+	case mtime < 0 || mtime > math.MaxUint32:
+		return nil, fmt.Errorf("%w: %s: mtime %d", ErrFieldOverflow, h.Name, mtime)
+	}
+	name := strings.TrimSuffix(h.Name, "/")
+	if name == "" {
+		name = "."
+	}
+	return &Entry{
+		Name:     name,
+		Mode:     uint32(h.Mode & 07777),
+		UID:      uint32(h.Uid),
+		GID:      uint32(h.Gid),
+		NLink:    1,
+		MTime:    uint32(mtime),
+		FileSize: uint32(h.Size),
+	}, nil
+}
@@ -0,0 +1,254 @@
+package cpio
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestToTar(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithUIDGID(1000, 100), WithMTimeUnix(1700000000))
+	for _, err := range []error{
+		wr.AddDir("bin", 0755),
+		wr.AddFile("bin/su", fs.ModeSetuid|0755, []byte("su")),
+		wr.AddSymlink("bin/sh", "busybox"),
+		wr.AddHardlink([]string{"a", "b", "c"}, 0644, []byte("shared")),
+		wr.AddDevice("dev/tty", fs.ModeDevice|fs.ModeCharDevice|0620, 5, 0),
+		wr.AddFIFO("run/fifo", 0600),
+		wr.AddSocket("run/sock", 0600),
+		wr.Close(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	warnings, err := ToTar(&buf, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || warnings[0].Name != "run/sock" {
+		t.Fatalf("got warnings %v", warnings)
+	}
+
+	tr := tar.NewReader(&out)
+	var got []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, h.Name)
+		if h.Uid != 1000 || h.Gid != 100 || h.ModTime.Unix() != 1700000000 {
+			t.Errorf("%s: got %d:%d %v", h.Name, h.Uid, h.Gid, h.ModTime)
+		}
+		switch h.Name {
+		case "bin/su":
+			if h.Mode != 04755 || h.Typeflag != tar.TypeReg {
+				t.Errorf("bin/su: got mode %o type %c", h.Mode, h.Typeflag)
+			}
+		case "bin/sh":
+			if h.Typeflag != tar.TypeSymlink || h.Linkname != "busybox" {
+				t.Errorf("bin/sh: got %+v", h)
+			}
+		case "c":
+			if b, _ := io.ReadAll(tr); h.Typeflag != tar.TypeReg || string(b) != "shared" {
+				t.Errorf("c: got type %c body %q", h.Typeflag, b)
+			}
+		case "a", "b":
+			if h.Typeflag != tar.TypeLink || h.Linkname != "c" {
+				t.Errorf("%s: got type %c link %q", h.Name, h.Typeflag, h.Linkname)
+			}
+		case "dev/tty":
+			if h.Typeflag != tar.TypeChar || h.Devmajor != 5 || h.Mode != 0620 {
+				t.Errorf("dev/tty: got %+v", h)
+			}
+		case "run/fifo":
+			if h.Typeflag != tar.TypeFifo {
+				t.Errorf("run/fifo: got type %c", h.Typeflag)
+			}
+		}
+	}
+	want := []string{"bin/", "bin/su", "bin/sh", "c", "a", "b", "dev/tty", "run/fifo"}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestToTarDroppedFields(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf, WithCRC())
+	for _, err := range []error{
+		wr.AddFile("etc/hostname", 0644, []byte("box")),
+		wr.WriteEntry(&Entry{Name: "etc", Mode: modeDir | 0755, NLink: 2, RDevMajor: 8, RDevMinor: 1}, nil),
+		wr.AddDevice("dev/sda", fs.ModeDevice|0660, 8, 0),
+		wr.Close(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	warnings, err := ToTar(&buf, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, w := range warnings {
+		got = append(got, w.String())
+	}
+	want := []string{"etc/hostname: crc checksum dropped", "etc: device number 8, 1 dropped"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got warnings %q, want %q", got, want)
+	}
+
+	tr := tar.NewReader(&out)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Name == "etc/" && (h.Devmajor != 0 || h.Devminor != 0) {
+			t.Errorf("etc/: got device %d, %d", h.Devmajor, h.Devminor)
+		}
+		if h.Name == "dev/sda" && h.Devmajor != 8 {
+			t.Errorf("dev/sda: got major %d", h.Devmajor)
+		}
+	}
+}
+
+func writeTar(t *testing.T, hdrs ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range hdrs {
+		if h.ModTime.IsZero() {
+			h.ModTime = time.Unix(1700000000, 0)
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 0 {
+			if _, err := tw.Write(bytes.Repeat([]byte("x"), int(h.Size))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFromTar(t *testing.T) {
+	data := writeTar(t,
+		&tar.Header{Typeflag: tar.TypeDir, Name: "etc/", Mode: 0755},
+		&tar.Header{Typeflag: tar.TypeReg, Name: "etc/passwd", Mode: 0644, Size: 3, Uid: 7, Gid: 8,
+			PAXRecords: map[string]string{"SCHILY.xattr.user.x": "y"}},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "etc/passwd-", Linkname: "etc/passwd"},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "etc/passwd.bak", Linkname: "etc/passwd-"},
+		&tar.Header{Typeflag: tar.TypeSymlink, Name: "bin", Linkname: "usr/bin", Mode: 0777},
+		&tar.Header{Typeflag: tar.TypeBlock, Name: "dev/sda", Mode: 0660, Devmajor: 8},
+		&tar.Header{Typeflag: tar.TypeFifo, Name: "run/fifo", Mode: 01600},
+	)
+
+	var out bytes.Buffer
+	warnings, err := FromTar(bytes.NewReader(data), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || warnings[0].Name != "etc/passwd" {
+		t.Fatalf("got warnings %v", warnings)
+	}
+
+	entries := readAll(t, out.Bytes())
+	if e := entries["etc"]; e == nil || !e.IsDir() {
+		t.Errorf("etc: got %+v", e)
+	}
+	p, l1, l2 := entries["etc/passwd"], entries["etc/passwd-"], entries["etc/passwd.bak"]
+	if p.FileSize != 3 || p.UID != 7 || p.NLink != 3 || l1.Ino != p.Ino || l2.Ino != p.Ino || l2.NLink != 3 {
+		t.Errorf("hardlinks: got %+v, %+v, %+v", p, l1, l2)
+	}
+	if e := entries["bin"]; e.Linkname != "usr/bin" {
+		t.Errorf("bin: got %+v", e)
+	}
+	if e := entries["dev/sda"]; e.Mode != modeBlock|0660 || e.RDevMajor != 8 {
+		t.Errorf("dev/sda: got %+v", e)
+	}
+	if e := entries["run/fifo"]; e.Mode != modeFIFO|01600 || e.MTime != 1700000000 {
+		t.Errorf("run/fifo: got %+v", e)
+	}
+	if entries["etc"].Ino == p.Ino || entries["bin"].Ino == entries["dev/sda"].Ino {
+		t.Error("inodes reused")
+	}
+}
+
+func TestFromTarDroppedFields(t *testing.T) {
+	data := writeTar(t, &tar.Header{
+		Typeflag: tar.TypeReg, Name: "a", Mode: 0644, Uname: "root", Gname: "wheel",
+		AccessTime: time.Unix(1700000001, 0), ChangeTime: time.Unix(1700000002, 0), Format: tar.FormatPAX,
+	})
+	warnings, err := FromTar(bytes.NewReader(data), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, w := range warnings {
+		got = append(got, w.String())
+	}
+	want := []string{`a: user name "root" dropped`, `a: group name "wheel" dropped`, "a: access time dropped", "a: change time dropped"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFromTarErrors(t *testing.T) {
+	link := writeTar(t,
+		&tar.Header{Typeflag: tar.TypeReg, Name: "a", Size: 1},
+		&tar.Header{Typeflag: tar.TypeLink, Name: "b", Linkname: "a"},
+	)
+	tests := []struct {
+		name string
+		r    io.Reader
+		err  error
+	}{
+		{"non-seekable link", struct{ io.Reader }{bytes.NewReader(link)}, ErrUnrepresentable},
+		{"dangling link", bytes.NewReader(writeTar(t, &tar.Header{Typeflag: tar.TypeLink, Name: "b", Linkname: "a"})), ErrUnrepresentable},
+		{"old mtime", bytes.NewReader(writeTar(t, &tar.Header{Typeflag: tar.TypeReg, Name: "a", ModTime: time.Unix(-1, 0)})), ErrFieldOverflow},
+		{"unknown type", bytes.NewReader(writeTar(t, &tar.Header{Typeflag: tar.TypeCont, Name: "a"})), ErrUnrepresentable},
+	}
+	// A uid above 32 bits only fits the tar header on 64-bit systems.
+	if math.MaxInt > math.MaxUint32 {
+		big := bytes.NewReader(writeTar(t, &tar.Header{Typeflag: tar.TypeReg, Name: "a", Uid: math.MaxInt}))
+		tests = append(tests, struct {
+			name string
+			r    io.Reader
+			err  error
+		}{"big uid", big, ErrFieldOverflow})
+	}
+	for _, tt := range tests {
+		if _, err := FromTar(tt.r, io.Discard); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rule is one step of a Transform pipeline.
+//
+// Apply is called for every entry of the input in archive order, with the
+// body left by the previous rules. It may modify e and returns the body to
+// write, or nil to delete the entry. A rule that replaces the body must
+// update e.FileSize. Symbolic link targets are in e.Linkname.
+type Rule interface {
+	Apply(e *Entry, body io.Reader) (io.Reader, error)
+}
+
+// Finisher is implemented by rules that write entries of their own once
+// the input is exhausted, before the trailer.
+type Finisher interface {
+	Finish(wr *Writer) error
+}
+
+// RuleFunc adapts a function to a Rule.
+type RuleFunc func(e *Entry, body io.Reader) (io.Reader, error)
+
+func (f RuleFunc) Apply(e *Entry, body io.Reader) (io.Reader, error) { return f(e, body) }
+
+// Match selects entries by name.
+type Match func(name string) bool
+
+// Glob matches entries whose name, or one of whose parent directories,
+// matches pattern with path.Match, so that a pattern naming a directory
+// also selects its contents.
+func Glob(pattern string) Match {
+	pattern = strings.Trim(pattern, "/")
+	return func(name string) bool {
+		_, ok := globPrefix(pattern, name)
+		return ok
+	}
+}
+
+// globPrefix returns the leading part of name matched by pattern.
+func globPrefix(pattern, name string) (string, bool) {
+	name = strings.Trim(name, "/")
+	for p := name; ; {
+		if ok, _ := path.Match(pattern, p); ok {
+			return p, true
+		}
+		i := strings.LastIndexByte(p, '/')
+		if i < 0 {
+			return "", false
+		}
+		p = p[:i]
+	}
+}
+
+// Regexp matches entries whose name matches re.
+func Regexp(re *regexp.Regexp) Match { return re.MatchString }
+
+// Transform streams the archive read from r to w, passing every entry
+// through rules in order. Nothing is unpacked to disk.
+//
+// The output uses the format of the input (newc for old binary input),
+// and entries keep their headers except where rules change them. Deleting
+// the link of a hard link group that carries the data leaves the other
+// links empty.
+func Transform(r io.Reader, w io.Writer, rules ...Rule) error {
+	return TransformWithOptions(r, w, nil, rules...)
+}
+
+// TransformWithOptions is Transform with reader options for the input, so
+// that WithDecompression and the limits work as for NewReader. With
+// WithConcatenated, each archive of the input is written as its own
+// archive, aligned as NewSegmentWriter does, and finishers add their
+// entries to the last one.
+func TransformWithOptions(r io.Reader, w io.Writer, opts []ReaderOption, rules ...Rule) error {
+	rd := NewReader(r, opts...)
+	cw := &countingWriter{w: w}
+	var wr *Writer
+	segment := 0
+	for {
+		e, err := rd.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if wr != nil && e.Segment != segment {
+			if err := wr.Close(); err != nil {
+				return err
+			}
+			wr = nil
+		}
+		if wr == nil {
+			if wr, err = NewSegmentWriter(cw, cw.n, formatOptions(e.Format)...); err != nil {
+				return err
+			}
+			segment = e.Segment
+		}
+
+		var body io.Reader = rd
+		for _, rule := range rules {
+			if body, err = rule.Apply(e, body); err != nil {
+				return err
+			}
+			if body == nil {
+				break
+			}
+		}
+		if body == nil {
+			continue
+		}
+		if err := wr.WriteEntry(e, body); err != nil {
+			return err
+		}
+	}
+
+	if wr == nil {
+		wr = NewWriter(w)
+	}
+	for _, rule := range rules {
+		if f, ok := rule.(Finisher); ok {
+			if err := f.Finish(wr); err != nil {
+				return err
+			}
+		}
+	}
+	return wr.Close()
+}
+
+// newFormatWriter returns a Writer emitting format f, or newc when f cannot
+// be written.
+func newFormatWriter(w io.Writer, f Format) *Writer {
+	return NewWriter(w, formatOptions(f)...)
+}
+
+// formatOptions returns the writer options selecting format f.
+func formatOptions(f Format) []WriterOption {
+	switch f {
+	case FormatCRC:
+		return []WriterOption{WithCRC()}
+	case FormatODC:
+		return []WriterOption{WithODC()}
+	default:
+		return nil
+	}
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Rename renames the entries matching re, replacing the matches as
+// regexp.Regexp.ReplaceAllString does.
+func Rename(re *regexp.Regexp, repl string) Rule {
+	return RuleFunc(func(e *Entry, body io.Reader) (io.Reader, error) {
+		e.Name = re.ReplaceAllString(e.Name, repl)
+		return body, nil
+	})
+}
+
+// RenameGlob renames the entries matching pattern, as Glob does, to name.
+// Entries below a matching directory are moved below name.
+func RenameGlob(pattern, name string) Rule {
+	pattern = strings.Trim(pattern, "/")
+	name = strings.Trim(name, "/")
+	return RuleFunc(func(e *Entry, body io.Reader) (io.Reader, error) {
+		if prefix, ok := globPrefix(pattern, e.Name); ok {
+			e.Name = name + strings.TrimPrefix(strings.Trim(e.Name, "/"), prefix)
+		}
+		return body, nil
+	})
+}
+
+// Chmod sets the permission, setuid, setgid and sticky bits of the entries
+// selected by m, keeping their file type.
+func Chmod(m Match, mode fs.FileMode) Rule {
+	return RuleFunc(func(e *Entry, body io.Reader) (io.Reader, error) {
+		if m(e.Name) {
+			e.Mode = e.Mode&modeTypeMask | permBits(mode)
+		}
+		return body, nil
+	})
+}
+
+// Chown sets the owner of the entries selected by m.
+func Chown(m Match, uid, gid uint32) Rule {
+	return RuleFunc(func(e *Entry, body io.Reader) (io.Reader, error) {
+		if m(e.Name) {
+			e.UID, e.GID = uid, gid
+		}
+		return body, nil
+	})
+}
+
+// Delete removes the entries selected by m.
+func Delete(m Match) Rule {
+	return RuleFunc(func(e *Entry, body io.Reader) (io.Reader, error) {
+		if m(e.Name) {
+			return nil, nil
+		}
+		return body, nil
+	})
+}
+
+// Replace replaces the contents of the regular files selected by m with
+// data.
+func Replace(m Match, data []byte) Rule {
+	return RuleFunc(func(e *Entry, body io.Reader) (io.Reader, error) {
+		if !m(e.Name) || !e.IsRegular() {
+			return body, nil
+		}
+		e.FileSize = uint32(len(data))
+		return bytes.NewReader(data), nil
+	})
+}
+
+// Append returns a rule that adds hdr with body at the end of the archive.
+// A zero hdr.Ino is replaced with an unused inode number.
+func Append(hdr *Entry, body []byte) Rule { return &appendRule{hdr: hdr, body: body} }
+
+type appendRule struct {
+	hdr  *Entry
+	body []byte
+}
+
+func (a *appendRule) Apply(e *Entry, body io.Reader) (io.Reader, error) { return body, nil }
+
+func (a *appendRule) Finish(wr *Writer) error {
+	h := *a.hdr
+	h.FileSize = uint32(len(a.body))
+	if h.Ino == 0 {
+		h.Ino = wr.ino
+	}
+	return wr.WriteEntry(&h, bytes.NewReader(a.body))
+}
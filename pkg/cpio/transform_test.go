@@ -0,0 +1,152 @@
+package cpio
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	var in bytes.Buffer
+	wr := NewWriter(&in, WithCRC(), WithUIDGID(1000, 1000))
+	for _, err := range []error{
+		wr.AddDir("lib/modules/6.1", 0755),
+		wr.AddFile("lib/modules/6.1/a.ko", 0644, []byte("module")),
+		wr.AddFile("init", 0755, []byte("old init")),
+		wr.AddFile("etc/shadow", 0644, []byte("root:x")),
+		wr.AddSymlink("bin/sh", "busybox"),
+		wr.AddFile("usr/share/doc/readme", 0644, []byte("doc")),
+		wr.Close(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	err := Transform(&in, &out,
+		Delete(Glob("lib/modules")),
+		Replace(Glob("init"), []byte("#!/bin/sh\n")),
+		Chmod(Glob("etc/shadow"), 0600),
+		Chown(Glob("*"), 0, 0),
+		Rename(regexp.MustCompile(`^bin/`), "usr/bin/"),
+		RenameGlob("usr/share/d?c", "usr/doc"),
+		Append(&Entry{Name: "etc/hostname", Mode: modeRegular | 0644, NLink: 1}, []byte("box")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rd := NewReader(&out, WithVerifyCRC())
+	got := map[string]*Entry{}
+	bodies := map[string]string{}
+	for {
+		e, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Format != FormatCRC {
+			t.Errorf("%s: got format %v", e.Name, e.Format)
+		}
+		b, err := io.ReadAll(rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[e.Name], bodies[e.Name] = e, string(b)
+	}
+
+	want := map[string]string{
+		"init":           "#!/bin/sh\n",
+		"etc/shadow":     "root:x",
+		"usr/bin/sh":     "",
+		"usr/doc/readme": "doc",
+		"etc/hostname":   "box",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for name, body := range want {
+		e := got[name]
+		if e == nil || bodies[name] != body || e.UID != 0 {
+			t.Errorf("%s: got %+v %q", name, e, bodies[name])
+		}
+	}
+	if m := got["etc/shadow"].FileMode(); m != 0600 {
+		t.Errorf("etc/shadow: got mode %v", m)
+	}
+	if l := got["usr/bin/sh"]; l.Linkname != "busybox" || l.FileMode() != fs.ModeSymlink|0777 {
+		t.Errorf("usr/bin/sh: got %+v", l)
+	}
+	if h := got["etc/hostname"]; h.Ino == got["init"].Ino {
+		t.Errorf("etc/hostname: reused inode %d", h.Ino)
+	}
+}
+
+func TestTransformSegments(t *testing.T) {
+	var img bytes.Buffer
+	wr := NewWriter(&img, WithCRC())
+	if err := wr.AddFile("kernel/x86/microcode/GenuineIntel.bin", 0644, []byte("ucode")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	img.Write(make([]byte, 509))
+	wr, err := NewSegmentWriter(&img, int64(img.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		wr.AddFile("init", 0755, []byte("#!/bin/sh")),
+		wr.AddFile("etc/shadow", 0644, []byte("root:x")),
+		wr.Close(),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var in bytes.Buffer
+	zw := gzip.NewWriter(&in)
+	zw.Write(img.Bytes())
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = TransformWithOptions(&in, &out, []ReaderOption{WithConcatenated(), WithDecompression()},
+		Delete(Glob("etc/shadow")),
+		Append(&Entry{Name: "etc/hostname", Mode: modeRegular | 0644, NLink: 1}, []byte("box")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rd := NewReader(&out, WithConcatenated(), WithVerifyCRC())
+	var got []string
+	for {
+		e, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%d %v %s", e.Segment, e.Format, e.Name))
+	}
+	want := []string{
+		fmt.Sprintf("0 %v kernel/x86/microcode/GenuineIntel.bin", FormatCRC),
+		fmt.Sprintf("1 %v init", FormatNewc),
+		fmt.Sprintf("1 %v etc/hostname", FormatNewc),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
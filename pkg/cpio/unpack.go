@@ -2,14 +2,22 @@ package cpio
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
-func safeJoin(dst, name string) (string, error) {
+// ErrUnsafePath is returned when an entry would be extracted outside the
+// destination, either through ".." components or through a symbolic link.
+var ErrUnsafePath = errors.New("cpio: unsafe path")
+
+// localName returns name as a clean path relative to the destination.
+func localName(name string) (string, error) {
 	name = filepath.ToSlash(name)
 	name = strings.TrimPrefix(name, "/")
 	clean := filepath.Clean(name)
@@ -17,45 +25,379 @@ func safeJoin(dst, name string) (string, error) {
 		return "", errors.New("cpio: invalid name")
 	}
 	if strings.HasPrefix(clean, "..") || strings.Contains(clean, "../") {
-		return "", errors.New("cpio: path traversal")
+		return "", fmt.Errorf("%w: %s: path traversal", ErrUnsafePath, name)
 	}
-	return filepath.Join(dst, filepath.FromSlash(clean)), nil
+	return filepath.FromSlash(clean), nil
 }
 
 func permsFromMode(mode uint32) fs.FileMode {
 	return fs.FileMode(mode & 0777)
 }
 
+// OverwritePolicy selects what Unpack does when a path already exists.
+// Existing directories are always merged.
+type OverwritePolicy int
+
+const (
+	// OverwriteReplace removes the existing file and extracts the entry.
+	OverwriteReplace OverwritePolicy = iota
+	// OverwriteSkip keeps the existing file and skips the entry.
+	OverwriteSkip
+	// OverwriteFail stops with an error wrapping fs.ErrExist.
+	OverwriteFail
+)
+
+// UnpackOptions controls how Unpack recreates entries on disk. The zero
+// value behaves like UnpackToDir.
+type UnpackOptions struct {
+	// PreserveOwner sets the uid and gid of every entry. It is ignored
+	// unless the process runs as root.
+	PreserveOwner bool
+	// PreserveMode sets the exact mode of every entry, including the
+	// setuid, setgid and sticky bits, regardless of the process umask.
+	// Directory modes are applied after their contents are written.
+	// Otherwise directories are created 0755 and files keep only their
+	// permission bits, subject to the process umask.
+	PreserveMode bool
+	// PreserveMTime sets the mtime of every entry but symbolic links, once
+	// the whole archive has been extracted.
+	PreserveMTime bool
+	// Overwrite selects what happens to existing files.
+	Overwrite OverwritePolicy
+	// Umask is cleared from the mode of every created file and directory.
+	Umask fs.FileMode
+	// Filter, if set, is called for each entry before it is extracted.
+	// Entries for which it returns false are skipped. It may change the
+	// name, mode bits, owner and mtime of the entry. Skipping part of a hard
+	// link group may leave the other links without data.
+	Filter func(*Entry) bool
+}
+
 // UnpackToDir unpacks a CPIO archive into dst.
-func UnpackToDir(r io.Reader, dst string) error {
-	rd := NewReader(r)
+//
+// Directories, regular files, symbolic links, hard links, device nodes,
+// FIFOs and sockets are recreated. Creating device nodes usually requires
+// root privileges.
+//
+// File bodies are streamed to disk, so memory use does not depend on the
+// size of the archive entries. Reader options can be passed to limit what
+// an untrusted archive may unpack.
+//
+// Nothing is written outside dst: entries whose path contains ".." or
+// goes through a symbolic link leading outside dst, whether extracted
+// earlier or already present in dst, are rejected with ErrUnsafePath.
+// Links that stay inside dst, such as lib -> usr/lib, are followed; a
+// directory entry over such a link keeps the link. Files and directories
+// are created through an os.Root, which also guards against links swapped
+// in while extracting, and the owner and mode of regular files are set
+// through the open file. Links and device nodes are created by path once
+// their parent directories have been checked; other metadata is set by
+// path without following symbolic links.
+func UnpackToDir(r io.Reader, dst string, opts ...ReaderOption) error {
+	return Unpack(r, dst, UnpackOptions{}, opts...)
+}
+
+// Unpack unpacks a CPIO archive into dst like UnpackToDir, with the
+// ownership, mode, mtime and overwrite handling selected by uo.
+func Unpack(r io.Reader, dst string, uo UnpackOptions, opts ...ReaderOption) error {
+	if err := os.MkdirAll(dst, 0755&^uo.Umask); err != nil {
+		return err
+	}
+	root, err := os.OpenRoot(dst)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	u := &unpacker{
+		rd:    NewReader(r, opts...),
+		root:  root,
+		dst:   dst,
+		o:     uo,
+		chown: uo.PreserveOwner && os.Geteuid() == 0,
+		links: map[LinkKey]string{},
+	}
 	for {
-		e, err := rd.Next()
+		e, err := u.rd.Next()
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				return nil
+				break
 			}
 			return err
 		}
-
-		outPath, err := safeJoin(dst, e.Name)
+		if u.o.Filter != nil && !u.o.Filter(e) {
+			continue
+		}
+		name, err := localName(e.Name)
 		if err != nil {
 			return err
 		}
+		if err := u.unpack(e, name); err != nil {
+			return err
+		}
+	}
+	return u.finish()
+}
+
+// unpacker holds the state of one Unpack call. Names are relative to the
+// root.
+type unpacker struct {
+	rd    *Reader
+	root  *os.Root
+	dst   string
+	o     UnpackOptions
+	chown bool
+	links map[LinkKey]string
+
+	// Directory modes and mtimes, applied once the archive is extracted.
+	dirs  []pathMeta
+	times []pathMeta
+}
+
+type pathMeta struct {
+	name  string
+	mode  fs.FileMode
+	mtime uint32
+}
 
-		isDir := (e.Mode & 0170000) == 0040000
-		if isDir {
-			if err := os.MkdirAll(outPath, 0755); err != nil {
+// path returns the on-disk path of name, for the operations os.Root does
+// not provide. The parents of name have been checked by mkdirAll.
+func (u *unpacker) path(name string) string {
+	return filepath.Join(u.dst, name)
+}
+
+func (u *unpacker) unpack(e *Entry, name string) error {
+	if e.IsDir() {
+		if err := u.mkdirAll(name); err != nil {
+			return err
+		}
+		if fi, err := u.root.Lstat(name); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		if u.o.PreserveMode {
+			u.dirs = append(u.dirs, pathMeta{name: name, mode: u.mode(e)})
+		}
+		return u.setMeta(e, name)
+	}
+
+	if err := u.mkdirAll(filepath.Dir(name)); err != nil {
+		return err
+	}
+
+	switch e.Mode & modeTypeMask {
+	case modeRegular:
+		return u.unpackRegular(e, name)
+	case modeSymlink:
+		return u.create(e, name, func() error { return os.Symlink(e.Linkname, u.path(name)) })
+	case modeChar, modeBlock, modeFIFO, modeSocket:
+		mode := e.Mode &^ uint32(u.o.Umask.Perm())
+		return u.create(e, name, func() error { return mknod(u.path(name), mode, e.RDevMajor, e.RDevMinor) })
+	default:
+		return fmt.Errorf("cpio: unsupported file type %o: %s", e.Mode&modeTypeMask, e.Name)
+	}
+}
+
+// mkdirAll creates the directory name and its parents one component at a
+// time. Components that are symbolic links are resolved through the root
+// and rejected if they lead outside it.
+func (u *unpacker) mkdirAll(name string) error {
+	if name == "." {
+		return nil
+	}
+	var dir string
+	for _, elem := range strings.Split(name, string(filepath.Separator)) {
+		dir = filepath.Join(dir, elem)
+		fi, err := u.root.Lstat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = u.root.Mkdir(dir, 0755&^u.o.Umask)
+			if err == nil {
+				continue
+			}
+			if errors.Is(err, fs.ErrExist) {
+				fi, err = u.root.Lstat(dir)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			fi, err = u.root.Stat(dir)
+			if errors.Is(err, fs.ErrNotExist) {
 				return err
 			}
-			continue
+			if err != nil {
+				return fmt.Errorf("%w: %s: symbolic link leaves the destination", ErrUnsafePath, filepath.ToSlash(dir))
+			}
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("cpio: %s: not a directory", filepath.ToSlash(dir))
 		}
+	}
+	return nil
+}
+
+// unpackRegular writes a regular file. Hard links to an inode already
+// extracted are linked to its first path; the data of the group, carried
+// by any of its entries, is written through the shared inode.
+func (u *unpacker) unpackRegular(e *Entry, name string) error {
+	perm := permsFromMode(e.Mode) &^ u.o.Umask
+	if e.NLink > 1 {
+		key := e.LinkKey()
+		if first, ok := u.links[key]; ok {
+			return u.create(e, name, func() error {
+				// A later entry may have replaced the first link.
+				if fi, err := u.root.Lstat(first); err != nil || !fi.Mode().IsRegular() {
+					return fmt.Errorf("%w: %s: hard link target %s replaced", ErrUnsafePath, e.Name, filepath.ToSlash(first))
+				}
+				if err := os.Link(u.path(first), u.path(name)); err != nil {
+					return err
+				}
+				if e.FileSize == 0 {
+					return nil
+				}
+				return u.writeLink(e, name, perm)
+			})
+		}
+		created := false
+		err := u.create(e, name, func() error {
+			created = true
+			return u.writeFile(e, name, os.O_EXCL, perm)
+		})
+		if created {
+			u.links[key] = name
+		}
+		return err
+	}
+	return u.create(e, name, func() error { return u.writeFile(e, name, os.O_EXCL, perm) })
+}
+
+// writeLink writes the data of a hard link group through a later link.
+// The first link may already have a read-only mode.
+func (u *unpacker) writeLink(e *Entry, name string, perm fs.FileMode) error {
+	if err := u.chmod(name, 0600); err != nil {
+		return err
+	}
+	return u.writeFile(e, name, os.O_TRUNC, perm)
+}
+
+// create applies the overwrite policy to name, runs create and sets the
+// metadata of the new file.
+func (u *unpacker) create(e *Entry, name string, create func() error) error {
+	if fi, err := u.root.Lstat(name); err == nil && !fi.IsDir() {
+		switch u.o.Overwrite {
+		case OverwriteSkip:
+			return nil
+		case OverwriteFail:
+			return fmt.Errorf("cpio: %s: %w", e.Name, fs.ErrExist)
+		}
+		if err := u.root.Remove(name); err != nil {
+			return err
+		}
+		u.forget(name)
+	}
+	if err := create(); err != nil {
+		return err
+	}
+	return u.setMeta(e, name)
+}
+
+// forget drops the deferred metadata of name, which was removed: it must
+// not be applied to whatever replaces it.
+func (u *unpacker) forget(name string) {
+	drop := func(m pathMeta) bool { return m.name == name }
+	u.dirs = slices.DeleteFunc(u.dirs, drop)
+	u.times = slices.DeleteFunc(u.times, drop)
+}
+
+// setMeta sets the owner and mode of name and records its mtime.
+// Ownership is set first, since changing it clears the setuid and setgid
+// bits. Directory modes are deferred to finish; regular files get their
+// owner and mode from writeFile.
+func (u *unpacker) setMeta(e *Entry, name string) error {
+	if u.chown && !e.IsRegular() {
+		if err := os.Lchown(u.path(name), int(e.UID), int(e.GID)); err != nil {
+			return err
+		}
+	}
+	if u.o.PreserveMode && !e.IsDir() && !e.IsSymlink() && !e.IsRegular() {
+		if err := u.chmod(name, u.mode(e)); err != nil {
+			return err
+		}
+	}
+	if u.o.PreserveMTime && !e.IsSymlink() {
+		u.times = append(u.times, pathMeta{name: name, mtime: e.MTime})
+	}
+	return nil
+}
+
+// mode returns the permission and special bits of e without the umask.
+func (u *unpacker) mode(e *Entry) fs.FileMode {
+	m := e.FileMode()
+	return m & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky) &^ u.o.Umask
+}
+
+// chmod sets the mode of name, refusing to follow it if it has become a
+// symbolic link.
+func (u *unpacker) chmod(name string, mode fs.FileMode) error {
+	fi, err := u.root.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("%w: %s: symbolic link", ErrUnsafePath, filepath.ToSlash(name))
+	}
+	return os.Chmod(u.path(name), mode)
+}
 
-		if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+// finish applies the directory modes and mtimes deferred until every entry
+// has been written, innermost directories first.
+func (u *unpacker) finish() error {
+	for i := len(u.dirs) - 1; i >= 0; i-- {
+		if err := u.chmod(u.dirs[i].name, u.dirs[i].mode); err != nil {
 			return err
 		}
-		if err := os.WriteFile(outPath, e.Data, permsFromMode(e.Mode)); err != nil {
+	}
+	for _, m := range u.times {
+		if err := lutimes(u.path(m.name), time.Unix(int64(m.mtime), 0)); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// writeFile streams the body of e into name, opened in the root with
+// O_WRONLY|O_CREATE|flag, and sets its owner and mode through the open
+// file. A file opened with O_TRUNC is an existing hard link made writable
+// by writeLink, whose permissions are restored to perm.
+func (u *unpacker) writeFile(e *Entry, name string, flag int, perm fs.FileMode) error {
+	f, err := u.root.OpenFile(name, os.O_WRONLY|os.O_CREATE|flag, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, u.rd); err != nil {
+		f.Close()
+		return err
+	}
+	if err := u.setFileMeta(f, e, flag&os.O_TRUNC != 0, perm); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// setFileMeta sets the owner and mode of the open file f. With restore,
+// the mode is perm unless PreserveMode selects the exact mode.
+func (u *unpacker) setFileMeta(f *os.File, e *Entry, restore bool, perm fs.FileMode) error {
+	if u.chown {
+		if err := f.Chown(int(e.UID), int(e.GID)); err != nil {
+			return err
+		}
+	}
+	switch {
+	case u.o.PreserveMode:
+		return f.Chmod(u.mode(e))
+	case restore:
+		return f.Chmod(perm)
+	}
+	return nil
 }
@@ -0,0 +1,359 @@
+package cpio
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is an entry for buildArchive and the body written with it.
+type archiveEntry struct {
+	*Entry
+	body string
+}
+
+// buildArchive writes the entries with WriteEntry and returns the archive.
+// File sizes are taken from the bodies.
+func buildArchive(t *testing.T, entries ...archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	wr := NewWriter(&buf)
+	for _, e := range entries {
+		e.FileSize = uint32(len(e.body))
+		if err := wr.WriteEntry(e.Entry, strings.NewReader(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func file(name string, mode, mtime uint32, body string) archiveEntry {
+	return archiveEntry{&Entry{Name: name, Mode: modeRegular | mode, NLink: 1, MTime: mtime}, body}
+}
+
+func dir(name string, mode, mtime uint32) archiveEntry {
+	return archiveEntry{Entry: &Entry{Name: name, Mode: modeDir | mode, NLink: 2, MTime: mtime}}
+}
+
+func TestUnpackPreserve(t *testing.T) {
+	data := buildArchive(t,
+		dir("ro", 0555, 1000),
+		file("ro/file", 0644, 2000, "x"),
+		file("su", 04755, 3000, "y"),
+		dir("tmp", 01777, 4000),
+		file("tmp/wide", 0666, 5000, "z"),
+	)
+
+	out := t.TempDir()
+	uo := UnpackOptions{PreserveMode: true, PreserveMTime: true, Umask: 0022}
+	if err := Unpack(bytes.NewReader(data), out, uo); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(out, "ro"), 0755)
+
+	tests := []struct {
+		name  string
+		mode  fs.FileMode
+		mtime int64
+	}{
+		{"ro", fs.ModeDir | 0555, 1000},
+		{"ro/file", 0644, 2000},
+		{"su", fs.ModeSetuid | 0755, 3000},
+		{"tmp", fs.ModeDir | fs.ModeSticky | 0755, 4000},
+		{"tmp/wide", 0644, 5000},
+	}
+	for _, tt := range tests {
+		fi, err := os.Lstat(filepath.Join(out, tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode() != tt.mode || fi.ModTime().Unix() != tt.mtime {
+			t.Errorf("%s: got %v %d, want %v %d", tt.name, fi.Mode(), fi.ModTime().Unix(), tt.mode, tt.mtime)
+		}
+	}
+}
+
+func TestUnpackOverwrite(t *testing.T) {
+	data := buildArchive(t, file("etc/conf", 0644, 0, "new"))
+
+	tests := []struct {
+		policy OverwritePolicy
+		want   string
+		err    error
+	}{
+		{OverwriteReplace, "new", nil},
+		{OverwriteSkip, "old", nil},
+		{OverwriteFail, "old", fs.ErrExist},
+	}
+	for _, tt := range tests {
+		out := t.TempDir()
+		path := filepath.Join(out, "etc", "conf")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := Unpack(bytes.NewReader(data), out, UnpackOptions{Overwrite: tt.policy})
+		if !errors.Is(err, tt.err) {
+			t.Errorf("policy %d: got error %v, want %v", tt.policy, err, tt.err)
+		}
+		if b, _ := os.ReadFile(path); string(b) != tt.want {
+			t.Errorf("policy %d: got %q, want %q", tt.policy, b, tt.want)
+		}
+	}
+}
+
+func TestUnpackFilter(t *testing.T) {
+	data := buildArchive(t,
+		file("lib/modules/a.ko", 0644, 0, "a"),
+		file("init", 0755, 0, "old"),
+		file("init.new", 0755, 0, "new"),
+	)
+
+	out := t.TempDir()
+	uo := UnpackOptions{Filter: func(e *Entry) bool {
+		switch {
+		case strings.HasPrefix(e.Name, "lib/modules/"), e.Name == "init":
+			return false
+		case e.Name == "init.new":
+			e.Name = "init"
+		}
+		return true
+	}}
+	if err := Unpack(bytes.NewReader(data), out, uo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "lib")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("lib: got %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(out, "init")); string(b) != "new" {
+		t.Errorf("init: got %q", b)
+	}
+}
+
+func TestUnpackReadOnlyHardlink(t *testing.T) {
+	var buf bytes.Buffer
+	wr := NewWriter(&buf)
+	if err := wr.AddHardlink([]string{"a", "b"}, 0444, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := t.TempDir()
+	if err := Unpack(bytes.NewReader(buf.Bytes()), out, UnpackOptions{PreserveMode: true}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(out, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(out, "a")); string(b) != "data" || fi.Mode() != 0444 {
+		t.Fatalf("got %q %v", b, fi.Mode())
+	}
+}
+
+func symlink(name, target string) archiveEntry {
+	return archiveEntry{Entry: &Entry{Name: name, Mode: modeSymlink | 0777, NLink: 1, Linkname: target}}
+}
+
+// TestUnpackMalicious checks that hostile archives cannot write outside the
+// destination directory.
+func TestUnpackMalicious(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries func(outside string) []archiveEntry
+		setup   func(t *testing.T, out, outside string)
+	}{
+		{"dotdot", func(string) []archiveEntry {
+			return []archiveEntry{file("../evil", 0644, 0, "x")}
+		}, nil},
+		{"absolute dotdot", func(string) []archiveEntry {
+			return []archiveEntry{file("/a/../../evil", 0644, 0, "x")}
+		}, nil},
+		{"symlink to root", func(string) []archiveEntry {
+			return []archiveEntry{symlink("etc", "/"), file("etc/evil", 0644, 0, "x")}
+		}, nil},
+		{"symlink to outside", func(outside string) []archiveEntry {
+			return []archiveEntry{symlink("etc", outside), file("etc/evil", 0644, 0, "x")}
+		}, nil},
+		{"relative symlink", func(string) []archiveEntry {
+			return []archiveEntry{dir("a", 0755, 0), symlink("a/up", "../.."), file("a/up/evil", 0644, 0, "x")}
+		}, nil},
+		{"nested symlink", func(outside string) []archiveEntry {
+			return []archiveEntry{symlink("l", outside), file("l/sub/evil", 0644, 0, "x")}
+		}, nil},
+		{"symlink then dir", func(outside string) []archiveEntry {
+			return []archiveEntry{symlink("d", outside), dir("d", 0755, 0), dir("d/evil", 0755, 0)}
+		}, nil},
+		{"symlink then device", func(outside string) []archiveEntry {
+			return []archiveEntry{symlink("dev", outside), {Entry: &Entry{Name: "dev/evil", Mode: modeFIFO | 0644, NLink: 1}}}
+		}, nil},
+		{"symlink then link", func(outside string) []archiveEntry {
+			return []archiveEntry{symlink("l", outside), symlink("l/evil", "x")}
+		}, nil},
+		{"replaced hardlink target", func(outside string) []archiveEntry {
+			return []archiveEntry{
+				{Entry: &Entry{Name: "a", Ino: 1, Mode: modeRegular | 0644, NLink: 2}},
+				symlink("a", filepath.Join(outside, "evil")),
+				{&Entry{Name: "b", Ino: 1, Mode: modeRegular | 0644, NLink: 2}, "x"},
+			}
+		}, nil},
+		{"existing symlink", func(string) []archiveEntry {
+			return []archiveEntry{file("etc/evil", 0644, 0, "x")}
+		}, func(t *testing.T, out, outside string) {
+			if err := os.Symlink(outside, filepath.Join(out, "etc")); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			out, outside := filepath.Join(base, "out"), filepath.Join(base, "outside")
+			for _, d := range []string{out, outside} {
+				if err := os.Mkdir(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.setup != nil {
+				tt.setup(t, out, outside)
+			}
+			data := buildArchive(t, tt.entries(outside)...)
+
+			err := UnpackToDir(bytes.NewReader(data), out)
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("got %v, want ErrUnsafePath", err)
+			}
+			for _, p := range []string{filepath.Join(base, "evil"), filepath.Join(outside, "evil"), filepath.Join(outside, "sub")} {
+				if _, err := os.Lstat(p); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("%s: got %v, want not exist", p, err)
+				}
+			}
+		})
+	}
+}
+
+func TestUnpackReplaceSymlink(t *testing.T) {
+	base := t.TempDir()
+	out, target := filepath.Join(base, "out"), filepath.Join(base, "target")
+	if err := os.WriteFile(target, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A file entry over an existing symlink replaces the link itself.
+	data := buildArchive(t, symlink("f", target), file("f", 0644, 0, "new"))
+	if err := UnpackToDir(bytes.NewReader(data), out); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(target); string(b) != "keep" {
+		t.Errorf("target: got %q", b)
+	}
+	if fi, err := os.Lstat(filepath.Join(out, "f")); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("f: got %v, %v", fi, err)
+	}
+}
+
+// TestUnpackReplacedMetadata checks that the deferred metadata of a
+// replaced file is not applied through the symlink replacing it.
+func TestUnpackReplacedMetadata(t *testing.T) {
+	base := t.TempDir()
+	out, target := filepath.Join(base, "out"), filepath.Join(base, "target")
+	if err := os.WriteFile(target, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buildArchive(t, file("x", 0600, 1000, "data"), symlink("x", target))
+	uo := UnpackOptions{PreserveMode: true, PreserveMTime: true}
+	if err := Unpack(bytes.NewReader(data), out, uo); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) || after.Mode() != before.Mode() {
+		t.Errorf("target: got %v %v, want %v %v", after.Mode(), after.ModTime(), before.Mode(), before.ModTime())
+	}
+}
+
+// TestUnpackFinishNoFollow checks that deferred metadata is never applied
+// through a symbolic link, even one left at a recorded path.
+func TestUnpackFinishNoFollow(t *testing.T) {
+	base := t.TempDir()
+	out, target := filepath.Join(base, "out"), filepath.Join(base, "target")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(target, filepath.Join(out, "x")); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := os.OpenRoot(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+
+	u := &unpacker{root: root, dst: out, times: []pathMeta{{name: "x", mtime: 1000}}}
+	// Linux sets the times of the link itself; other systems refuse it.
+	u.finish()
+	u = &unpacker{root: root, dst: out, dirs: []pathMeta{{name: "x", mode: 0700}}}
+	if err := u.finish(); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("dir mode: got %v, want ErrUnsafePath", err)
+	}
+	after, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) || after.Mode() != before.Mode() {
+		t.Errorf("target: got %v %v, want %v %v", after.Mode(), after.ModTime(), before.Mode(), before.ModTime())
+	}
+}
+
+// TestUnpackInRootSymlink checks that links staying in the destination,
+// as in merged-/usr images, are followed.
+func TestUnpackInRootSymlink(t *testing.T) {
+	out := t.TempDir()
+	data := buildArchive(t,
+		dir("usr", 0755, 0),
+		dir("usr/lib", 0755, 0),
+		symlink("lib", "usr/lib"),
+		file("lib/libc.so", 0644, 0, "elf"),
+		dir("lib", 0700, 0),
+		dir("lib/modules", 0755, 0),
+	)
+	uo := UnpackOptions{PreserveMode: true, PreserveMTime: true}
+	if err := Unpack(bytes.NewReader(data), out, uo); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "usr", "lib", "libc.so")); err != nil || string(b) != "elf" {
+		t.Errorf("usr/lib/libc.so: got %q, %v", b, err)
+	}
+	if fi, err := os.Stat(filepath.Join(out, "usr", "lib", "modules")); err != nil || !fi.IsDir() {
+		t.Errorf("usr/lib/modules: got %v, %v", fi, err)
+	}
+	if target, err := os.Readlink(filepath.Join(out, "lib")); err != nil || target != "usr/lib" {
+		t.Errorf("lib: got %q, %v", target, err)
+	}
+	if fi, err := os.Stat(filepath.Join(out, "usr", "lib")); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("usr/lib: got %v, %v", fi, err)
+	}
+}
@@ -0,0 +1,32 @@
+package cpio
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Flags of utimensat, the same on every Linux architecture.
+const (
+	atFDCWD           = -0x64
+	atSymlinkNoFollow = 0x100
+)
+
+// lutimes sets the access and modification times of path to t without
+// following a final symbolic link.
+func lutimes(path string, t time.Time) error {
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	ts := syscall.NsecToTimespec(t.UnixNano())
+	times := [2]syscall.Timespec{ts, ts}
+	dirfd := atFDCWD
+	_, _, errno := syscall.Syscall6(syscall.SYS_UTIMENSAT, uintptr(dirfd), uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&times[0])), atSymlinkNoFollow, 0, 0)
+	if errno != 0 {
+		return &fs.PathError{Op: "utimensat", Path: path, Err: errno}
+	}
+	return nil
+}
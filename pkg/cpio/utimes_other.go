@@ -0,0 +1,23 @@
+//go:build !linux
+
+package cpio
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// lutimes sets the access and modification times of path to t. Symbolic
+// links are refused rather than followed.
+func lutimes(path string, t time.Time) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		return fmt.Errorf("%w: %s: symbolic link", ErrUnsafePath, path)
+	}
+	return os.Chtimes(path, t, t)
+}
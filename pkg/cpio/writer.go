@@ -1,10 +1,11 @@
 package cpio
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
-	"os"
 	"path/filepath"
 	"strings"
 )
@@ -20,28 +21,55 @@ func hex8To(dst []byte, v uint32) []byte {
 	return append(dst, out[:]...)
 }
 
-func writeNewcHeader(w io.Writer, ino, mode, uid, gid, nlink, mtime, filesize, devmaj, devmin, rdevmaj, rdevmin, namesize uint32) error {
+func writeNewcHeader(w io.Writer, magic string, h *Entry, namesize uint32) error {
 	b := make([]byte, 0, 110)
-	b = append(b, magicNewc...)
-	b = hex8To(b, ino)
-	b = hex8To(b, mode)
-	b = hex8To(b, uid)
-	b = hex8To(b, gid)
-	b = hex8To(b, nlink)
-	b = hex8To(b, mtime)
-	b = hex8To(b, filesize)
-	b = hex8To(b, devmaj)
-	b = hex8To(b, devmin)
-	b = hex8To(b, rdevmaj)
-	b = hex8To(b, rdevmin)
+	b = append(b, magic...)
+	b = hex8To(b, h.Ino)
+	b = hex8To(b, h.Mode)
+	b = hex8To(b, h.UID)
+	b = hex8To(b, h.GID)
+	b = hex8To(b, h.NLink)
+	b = hex8To(b, h.MTime)
+	b = hex8To(b, h.FileSize)
+	b = hex8To(b, h.DevMajor)
+	b = hex8To(b, h.DevMinor)
+	b = hex8To(b, h.RDevMajor)
+	b = hex8To(b, h.RDevMinor)
 	b = hex8To(b, namesize)
-	b = hex8To(b, 0) // check
+	b = hex8To(b, h.Check)
 	_, err := w.Write(b)
 	return err
 }
 
-func writePad4(w io.Writer, n uint32) error {
-	p := pad4(n)
+func writeODCHeader(w io.Writer, h *Entry, namesize uint32) error {
+	fields := [10]uint64{
+		uint64(encodeDev(h.DevMajor, h.DevMinor)),
+		uint64(h.Ino),
+		uint64(h.Mode),
+		uint64(h.UID),
+		uint64(h.GID),
+		uint64(h.NLink),
+		uint64(encodeDev(h.RDevMajor, h.RDevMinor)),
+		uint64(h.MTime),
+		uint64(namesize),
+		uint64(h.FileSize),
+	}
+	names := [10]string{"dev", "ino", "mode", "uid", "gid", "nlink", "rdev", "mtime", "namesize", "filesize"}
+
+	b := make([]byte, 0, 76)
+	b = append(b, magicODC...)
+	for i, v := range fields {
+		var ok bool
+		if b, ok = appendOctal(b, v, odcWidths[i]); !ok {
+			return fmt.Errorf("%w: %s: odc %s %d", ErrFieldOverflow, h.Name, names[i], v)
+		}
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// writePad writes p zero bytes of alignment padding.
+func writePad(w io.Writer, p uint32) error {
 	if p == 0 {
 		return nil
 	}
@@ -67,114 +95,291 @@ func normalizeName(name string) (string, error) {
 	return clean, nil
 }
 
-func (wr *Writer) AddDir(name string, mode fs.FileMode) error {
-	if wr == nil || wr.closed {
-		return errors.New("cpio: writer closed")
+var errWriterClosed = errors.New("cpio: writer closed")
+
+// writeEntry writes h followed by exactly h.FileSize bytes from body.
+// In crc mode it also fills h.Check, otherwise it clears it. Once the
+// header is being written, any error leaves a corrupt archive and fails
+// every later call.
+func (wr *Writer) writeEntry(h *Entry, body io.Reader) error {
+	if wr.err != nil {
+		return wr.err
 	}
-	name, err := normalizeName(name)
-	if err != nil {
+	wr.normalize(h)
+	h.Check = 0
+	if wr.format == FormatCRC {
+		sum, r, err := checksumBody(body, h.FileSize)
+		if err != nil {
+			return err
+		}
+		h.Check, body = sum, r
+	}
+	if err := wr.writeRecord(h, body); err != nil {
+		wr.err = err
 		return err
 	}
-	perm := uint32(mode.Perm())
-	cmode := uint32(0040000) | perm
-	namesize := uint32(len(name) + 1)
-	if err := writeNewcHeader(wr.w, wr.ino, cmode, wr.uid, wr.gid, 2, wr.mtime, 0, 0, 0, 0, 0, namesize); err != nil {
+	return nil
+}
+
+// writeRecord writes the header, name and body of h.
+func (wr *Writer) writeRecord(h *Entry, body io.Reader) error {
+	namesize := uint32(len(h.Name) + 1)
+	var err error
+	switch wr.format {
+	case FormatCRC:
+		err = writeNewcHeader(wr.w, magicCrc, h, namesize)
+	case FormatODC:
+		err = writeODCHeader(wr.w, h, namesize)
+	default:
+		err = writeNewcHeader(wr.w, magicNewc, h, namesize)
+	}
+	if err != nil {
 		return err
 	}
-	wr.ino++
-	if _, err := io.WriteString(wr.w, name); err != nil {
+	if _, err := io.WriteString(wr.w, h.Name); err != nil {
 		return err
 	}
 	if _, err := wr.w.Write([]byte{0}); err != nil {
 		return err
 	}
-	return writePad4(wr.w, 110+namesize)
+	if err := writePad(wr.w, wr.format.pad(wr.format.headerSize()+namesize)); err != nil {
+		return err
+	}
+	if h.FileSize > 0 {
+		if body == nil {
+			return fmt.Errorf("cpio: %s: missing body", h.Name)
+		}
+		n, err := io.CopyN(wr.w, body, int64(h.FileSize))
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: %s: body is %d bytes, want %d", ErrSizeMismatch, h.Name, n, h.FileSize)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return writePad(wr.w, wr.format.pad(h.FileSize))
 }
 
-func (wr *Writer) AddFile(name string, mode fs.FileMode, data []byte) error {
+// newEntry returns a header for name carrying the writer defaults.
+func (wr *Writer) newEntry(name string, mode uint32) *Entry {
+	return &Entry{
+		Name:  name,
+		Ino:   wr.ino,
+		Mode:  mode,
+		UID:   wr.uid,
+		GID:   wr.gid,
+		NLink: 1,
+		MTime: wr.mtime,
+	}
+}
+
+// addEntry writes a complete entry with its own inode number.
+func (wr *Writer) addEntry(name string, mode uint32, data []byte, fill func(*Entry)) error {
+	return wr.addEntryFrom(name, mode, int64(len(data)), bytes.NewReader(data), fill)
+}
+
+// addEntryFrom is addEntry with a body of size bytes read from body.
+func (wr *Writer) addEntryFrom(name string, mode uint32, size int64, body io.Reader, fill func(*Entry)) error {
 	if wr == nil || wr.closed {
-		return errors.New("cpio: writer closed")
+		return errWriterClosed
 	}
 	name, err := normalizeName(name)
 	if err != nil {
 		return err
 	}
-	perm := uint32(mode.Perm())
-	cmode := uint32(0100000) | perm
-	filesize := uint32(len(data))
-	namesize := uint32(len(name) + 1)
-	if err := writeNewcHeader(wr.w, wr.ino, cmode, wr.uid, wr.gid, 1, wr.mtime, filesize, 0, 0, 0, 0, namesize); err != nil {
+	if err := checkFileSize(name, size); err != nil {
 		return err
 	}
-	wr.ino++
-	if _, err := io.WriteString(wr.w, name); err != nil {
-		return err
+	h := wr.newEntry(name, mode)
+	h.FileSize = uint32(size)
+	if fill != nil {
+		fill(h)
 	}
-	if _, err := wr.w.Write([]byte{0}); err != nil {
+	if err := wr.writeEntry(h, body); err != nil {
 		return err
 	}
-	if err := writePad4(wr.w, 110+namesize); err != nil {
+	if err := checkBodyEnd(name, size, body); err != nil {
+		wr.err = err
 		return err
 	}
-	if filesize > 0 {
-		if _, err := wr.w.Write(data); err != nil {
-			return err
-		}
+	wr.ino++
+	return nil
+}
+
+// checkFileSize reports whether size fits the filesize header field.
+func checkFileSize(name string, size int64) error {
+	if size < 0 || size > MaxFileSize {
+		return fmt.Errorf("%w: %s: filesize %d", ErrFieldOverflow, name, size)
 	}
-	return writePad4(wr.w, filesize)
+	return nil
 }
 
-// Close writes the TRAILER!!! entry.
-func (wr *Writer) Close() error {
+// checkBodyEnd reports whether body, from which size bytes were copied,
+// holds more data. Only in-memory readers with a Len method, such as
+// *bytes.Reader and *strings.Reader, are checked: reading on could block
+// on a pipe or take data that belongs to the caller.
+func checkBodyEnd(name string, size int64, body io.Reader) error {
+	if b, ok := body.(interface{ Len() int }); ok && b.Len() > 0 {
+		return fmt.Errorf("%w: %s: body is longer than %d bytes", ErrSizeMismatch, name, size)
+	}
+	return nil
+}
+
+// WriteEntry writes hdr followed by hdr.FileSize bytes read from body.
+//
+// Unlike the Add* helpers, every header field is written as given: the
+// name is not normalised and Ino, UID, GID, NLink and MTime are not taken
+// from the writer, except for the rewrites of WithClampMTime, WithOwner and
+// WithStableInodes. For symbolic links with a Linkname, the target is
+// written from Linkname and body is ignored.
+// Copying entries from a Reader through WriteEntry reproduces their headers.
+//
+// The Check field is ignored: it is computed from the body in crc mode and
+// written as zero otherwise.
+func (wr *Writer) WriteEntry(hdr *Entry, body io.Reader) error {
 	if wr == nil || wr.closed {
-		return nil
+		return errWriterClosed
 	}
-	wr.closed = true
-	trail := "TRAILER!!!"
-	namesize := uint32(len(trail) + 1)
-	_ = writeNewcHeader(wr.w, wr.ino, 0, 0, 0, 1, wr.mtime, 0, 0, 0, 0, 0, namesize)
-	_, _ = io.WriteString(wr.w, trail)
-	_, _ = wr.w.Write([]byte{0})
-	return writePad4(wr.w, 110+namesize)
+	if hdr.Name == "" || strings.IndexByte(hdr.Name, 0) >= 0 || hdr.Name == "TRAILER!!!" {
+		return fmt.Errorf("cpio: invalid name %q", hdr.Name)
+	}
+	h := *hdr
+	if h.IsSymlink() && h.Linkname != "" {
+		h.FileSize = uint32(len(h.Linkname))
+		body = strings.NewReader(h.Linkname)
+	}
+	if err := wr.writeEntry(&h, body); err != nil {
+		return err
+	}
+	// Keep inodes assigned by the Add* helpers clear of explicit ones.
+	if h.Ino >= wr.ino {
+		wr.ino = h.Ino + 1
+	}
+	return nil
+}
+
+// AddDir adds a directory entry.
+func (wr *Writer) AddDir(name string, mode fs.FileMode) error {
+	return wr.addEntry(name, modeDir|permBits(mode), nil, func(h *Entry) { h.NLink = 2 })
+}
+
+// AddFile adds a regular file with the given contents.
+func (wr *Writer) AddFile(name string, mode fs.FileMode, data []byte) error {
+	return wr.addEntry(name, modeRegular|permBits(mode), data, nil)
+}
+
+// AddFileFrom adds a regular file of size bytes streamed from r. A size
+// larger than MaxFileSize is reported with ErrFieldOverflow, and a body
+// shorter than size with ErrSizeMismatch, which also fails every later
+// call, Close included. Exactly size bytes are read from r; a longer body
+// is only reported for in-memory readers such as *bytes.Reader. In crc
+// mode, r is buffered in memory unless it is an io.ReadSeeker.
+func (wr *Writer) AddFileFrom(name string, mode fs.FileMode, size int64, r io.Reader) error {
+	return wr.addEntryFrom(name, modeRegular|permBits(mode), size, r, nil)
+}
+
+// AddSymlink adds a symbolic link pointing to target.
+func (wr *Writer) AddSymlink(name, target string) error {
+	if target == "" {
+		return errors.New("cpio: empty symlink target")
+	}
+	return wr.addEntry(name, modeSymlink|0777, []byte(target), nil)
+}
+
+// AddHardlink adds a group of hard links sharing one inode.
+//
+// Every entry of the group carries the same inode number and
+// nlink = len(names). Following the newc rules only the last one carries
+// the data; in odc, as GNU cpio expects, the first one does.
+func (wr *Writer) AddHardlink(names []string, mode fs.FileMode, data []byte) error {
+	return wr.addHardlink(names, mode, data, nil)
 }
 
-// PackDir packs an on-disk directory into a CPIO archive (deterministic order).
-func PackDir(root string, w io.Writer, opts ...WriterOption) error {
-	wr := NewWriter(w, opts...)
-	defer wr.Close()
+func (wr *Writer) addHardlink(names []string, mode fs.FileMode, data []byte, fill func(*Entry)) error {
+	return wr.addHardlinkFrom(names, mode, int64(len(data)), bytes.NewReader(data), fill)
+}
 
-	root = filepath.Clean(root)
-	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+// addHardlinkFrom is addHardlink with a body of size bytes read from body.
+func (wr *Writer) addHardlinkFrom(names []string, mode fs.FileMode, size int64, body io.Reader, fill func(*Entry)) error {
+	if wr == nil || wr.closed {
+		return errWriterClosed
+	}
+	if len(names) == 0 {
+		return errors.New("cpio: empty hardlink group")
+	}
+	clean := make([]string, len(names))
+	for i, name := range names {
+		n, err := normalizeName(name)
 		if err != nil {
 			return err
 		}
-		rel, err := filepath.Rel(root, path)
-		if err != nil {
-			return err
+		clean[i] = n
+	}
+	data := len(clean) - 1
+	if wr.format == FormatODC {
+		data = 0
+	}
+	if err := checkFileSize(clean[data], size); err != nil {
+		return err
+	}
+
+	for i, name := range clean {
+		h := wr.newEntry(name, modeRegular|permBits(mode))
+		h.NLink = uint32(len(clean))
+		if fill != nil {
+			fill(h)
 		}
-		rel = filepath.ToSlash(rel)
-		if rel == "." {
-			return nil
+		var r io.Reader = bytes.NewReader(nil)
+		if i == data {
+			r = body
+			h.FileSize = uint32(size)
 		}
-
-		info, err := d.Info()
-		if err != nil {
+		if err := wr.writeEntry(h, r); err != nil {
 			return err
 		}
+	}
+	if err := checkBodyEnd(clean[data], size, body); err != nil {
+		wr.err = err
+		return err
+	}
+	wr.ino++
+	return nil
+}
 
-		if d.IsDir() {
-			return wr.AddDir(rel, 0755)
-		}
+// AddDevice adds a character or block device node. The mode must include
+// fs.ModeDevice, and fs.ModeCharDevice for character devices.
+func (wr *Writer) AddDevice(name string, mode fs.FileMode, major, minor uint32) error {
+	if mode&fs.ModeDevice == 0 {
+		return errors.New("cpio: not a device mode")
+	}
+	return wr.addEntry(name, cpioMode(mode), nil, func(h *Entry) {
+		h.RDevMajor = major
+		h.RDevMinor = minor
+	})
+}
 
-		mode := fs.FileMode(0644)
-		if info.Mode()&0100 != 0 {
-			mode = 0755
-		}
+// AddFIFO adds a named pipe.
+func (wr *Writer) AddFIFO(name string, mode fs.FileMode) error {
+	return wr.addEntry(name, modeFIFO|permBits(mode), nil, nil)
+}
 
-		b, err := os.ReadFile(path)
-		if err != nil {
-			return err
+// AddSocket adds a unix domain socket node.
+func (wr *Writer) AddSocket(name string, mode fs.FileMode) error {
+	return wr.addEntry(name, modeSocket|permBits(mode), nil, nil)
+}
+
+// Close writes the TRAILER!!! entry and closes the compressor, if any.
+// The compressor is closed even after an error, which is returned first.
+func (wr *Writer) Close() error {
+	if wr == nil || wr.closed {
+		return nil
+	}
+	wr.closed = true
+	err := wr.writeEntry(&Entry{Name: "TRAILER!!!", Ino: wr.ino, NLink: 1, MTime: wr.mtime}, nil)
+	if wr.cw != nil {
+		if cerr := wr.cw.Close(); err == nil {
+			err = cerr
 		}
-		return wr.AddFile(rel, mode, b)
-	})
+	}
+	return err
 }
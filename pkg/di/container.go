@@ -19,7 +19,7 @@ type Container struct {
 	mu        sync.RWMutex
 }
 
-var injectParser = tags.NewParser("inject", tags.WithPairDelimiter(";"), tags.WithKVSeparator(":"))
+var injectParser = tags.NewParser("inject", tags.WithPairDelimiter(";"), tags.WithKVSeparator(":"), tags.WithEmbedded())
 
 // New creates an empty DI container.
 func New() *Container {
@@ -69,8 +69,10 @@ func (c *Container) Has(name string) bool {
 }
 
 // Inject populates struct fields with registered dependencies.
-// Uses `inject:"name"` tags to match fields with providers.
-// Falls back to field name if no inject tag is present.
+// Uses `inject:"name"` tags to match fields with providers, including
+// fields promoted from embedded structs. Exported fields without an inject
+// tag fall back to their field name. Fields promoted through a nil
+// embedded pointer are skipped.
 func (c *Container) Inject(target any) {
 	val := reflect.ValueOf(target)
 	if val.Kind() != reflect.Ptr || val.Elem().Kind() != reflect.Struct {
@@ -86,22 +88,33 @@ func (c *Container) Inject(target any) {
 	defer c.mu.RUnlock()
 
 	for _, meta := range fields {
-		fieldVal := elem.Field(meta.Index)
-		if !fieldVal.CanSet() {
-			continue
-		}
+		c.injectField(elem, meta.IndexPath, meta.RawTag)
+	}
 
-		name := meta.RawTag
-		if name == "" {
-			name = meta.Name
+	// The parser only returns tagged fields; untagged ones are matched by
+	// name here.
+	for _, field := range reflect.VisibleFields(elem.Type()) {
+		if field.Anonymous || !field.IsExported() || field.Tag.Get("inject") != "" {
+			continue
 		}
+		c.injectField(elem, field.Index, field.Name)
+	}
+}
 
-		if dep, ok := c.providers[name]; ok {
-			depVal := reflect.ValueOf(dep)
-			if depVal.Type().AssignableTo(fieldVal.Type()) {
-				fieldVal.Set(depVal)
-			}
-		}
+// injectField sets the field of elem at index to the provider name, if
+// it exists and is assignable. The caller holds c.mu.
+func (c *Container) injectField(elem reflect.Value, index []int, name string) {
+	dep, ok := c.providers[name]
+	if !ok {
+		return
+	}
+	fieldVal, err := elem.FieldByIndexErr(index)
+	if err != nil || !fieldVal.CanSet() {
+		return
+	}
+	depVal := reflect.ValueOf(dep)
+	if depVal.Type().AssignableTo(fieldVal.Type()) {
+		fieldVal.Set(depVal)
 	}
 }
 
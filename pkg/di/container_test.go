@@ -158,3 +158,42 @@ func TestMustResolve_Panic(t *testing.T) {
 
 	MustResolve[int](c, "missing")
 }
+
+type testBase struct {
+	DB *testDB `inject:"db"`
+}
+
+type testRepo struct {
+	Cache string `inject:"cache"`
+}
+
+type testEmbeddedService struct {
+	testBase
+	*testRepo
+	Logger string
+}
+
+func TestContainer_InjectEmbedded(t *testing.T) {
+	c := New()
+	db := &testDB{Name: "embedded"}
+	c.Provide("db", db)
+	c.Provide("cache", "redis")
+	c.Provide("Logger", "stdout")
+
+	svc := &testEmbeddedService{}
+	c.Inject(svc)
+
+	if svc.DB != db {
+		t.Error("DB not injected into embedded struct")
+	}
+	if svc.Logger != "stdout" {
+		t.Errorf("Logger: got %q, want %q", svc.Logger, "stdout")
+	}
+
+	// Fields behind the nil embedded pointer were skipped; set it and retry.
+	svc.testRepo = &testRepo{}
+	c.Inject(svc)
+	if svc.Cache != "redis" {
+		t.Errorf("Cache: got %q, want %q", svc.Cache, "redis")
+	}
+}
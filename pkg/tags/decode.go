@@ -0,0 +1,157 @@
+package tags
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	bind "github.com/mirkobrombin/go-foundation/pkg/reflect"
+)
+
+// Decode parses tag and fills the struct pointed to by out, as
+// FieldMeta.Decode does.
+func (p *Parser) Decode(tag string, out any) error {
+	pairs, err := p.Tokenize(tag)
+	if err != nil {
+		return err
+	}
+	values := make(map[string][]string, len(pairs))
+	for _, pair := range pairs {
+		values[pair.Key] = pair.Values
+	}
+	return decode(values, out)
+}
+
+// Decode fills the struct pointed to by out from the parsed tag.
+//
+// Each exported field of out is matched to a key of the same name,
+// compared case-insensitively, or to the name given by a `tag:"name"`
+// field tag; `tag:"-"` skips the field. An exact match wins over keys
+// differing in case, which are tried in sorted order. Values are
+// converted with reflect.Bind. A key without a value sets a bool field to
+// true. Slice fields take every value of their key; other fields take
+// exactly one. Fields whose key is absent are left untouched.
+//
+// Example:
+//
+//	var spec struct {
+//		Min      int
+//		Roles    []string
+//		Optional bool
+//	}
+//	err := meta.Decode(&spec) // from `check:"min:3; roles:a,b; optional"`
+func (m FieldMeta) Decode(out any) error {
+	return decode(m.Tags, out)
+}
+
+// specField maps a key to a field of a spec struct.
+type specField struct {
+	key   string
+	index int
+}
+
+var (
+	specCache = make(map[reflect.Type][]specField)
+	specMu    sync.RWMutex
+)
+
+// specFields returns the decodable fields of the spec struct typ.
+func specFields(typ reflect.Type) []specField {
+	specMu.RLock()
+	if cached, ok := specCache[typ]; ok {
+		specMu.RUnlock()
+		return cached
+	}
+	specMu.RUnlock()
+
+	specMu.Lock()
+	defer specMu.Unlock()
+
+	if cached, ok := specCache[typ]; ok {
+		return cached
+	}
+
+	var fields []specField
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		key := field.Tag.Get("tag")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = field.Name
+		}
+		fields = append(fields, specField{key: key, index: i})
+	}
+
+	specCache[typ] = fields
+	return fields
+}
+
+func decode(values map[string][]string, out any) error {
+	val := reflect.ValueOf(out)
+	if val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("tags: Decode requires a non-nil struct pointer, got %T", out)
+	}
+	elem := val.Elem()
+
+	for _, f := range specFields(elem.Type()) {
+		key, vals, ok := lookupKey(values, f.key)
+		if !ok {
+			continue
+		}
+		if err := setField(elem.Field(f.index), vals); err != nil {
+			return fmt.Errorf("tags: key %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// lookupKey finds key in values, preferring an exact match. Among keys
+// differing only in case, the first in sorted order wins.
+func lookupKey(values map[string][]string, key string) (string, []string, bool) {
+	if vals, ok := values[key]; ok {
+		return key, vals, true
+	}
+	for _, k := range slices.Sorted(maps.Keys(values)) {
+		if strings.EqualFold(k, key) {
+			return k, values[k], true
+		}
+	}
+	return "", nil, false
+}
+
+func setField(field reflect.Value, vals []string) error {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		field = field.Elem()
+	}
+
+	switch {
+	case field.Kind() == reflect.Bool && vals == nil:
+		field.SetBool(true)
+		return nil
+	case field.Kind() == reflect.Slice:
+		slice := reflect.MakeSlice(field.Type(), 0, len(vals))
+		for _, v := range vals {
+			item := reflect.New(field.Type().Elem()).Elem()
+			if err := bind.Bind(item, v); err != nil {
+				return err
+			}
+			slice = reflect.Append(slice, item)
+		}
+		field.Set(slice)
+		return nil
+	case len(vals) != 1:
+		return fmt.Errorf("expected one value, got %d", len(vals))
+	}
+	return bind.Bind(field, vals[0])
+}
@@ -0,0 +1,111 @@
+package tags
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type checkSpec struct {
+	Min      int
+	Max      *int
+	Roles    []string
+	Ports    []uint16
+	Optional bool
+	Timeout  time.Duration
+	Pattern  string `tag:"regex"`
+	Skipped  string `tag:"-"`
+	hidden   string
+}
+
+func TestParser_Decode(t *testing.T) {
+	p := NewParser("check")
+
+	var spec checkSpec
+	err := p.Decode(`min:3; MAX:9; roles:admin,user; ports:80,443; optional; timeout:2s; regex:"^a,b$"; skipped:x; hidden:y`, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit := 9
+	want := checkSpec{
+		Min:      3,
+		Max:      &limit,
+		Roles:    []string{"admin", "user"},
+		Ports:    []uint16{80, 443},
+		Optional: true,
+		Timeout:  2 * time.Second,
+		Pattern:  "^a,b$",
+	}
+	if !reflect.DeepEqual(spec, want) {
+		t.Errorf("got %+v, want %+v", spec, want)
+	}
+
+	errs := []struct {
+		tag  string
+		want string
+	}{
+		{tag: "min:x", want: `tags: key "min": reflect: invalid integer: x`},
+		{tag: "min:1,2", want: `tags: key "min": expected one value, got 2`},
+		{tag: "min", want: `tags: key "min": expected one value, got 0`},
+		{tag: "optional:maybe", want: `tags: key "optional": reflect: invalid boolean value: maybe`},
+		{tag: `min:"1`, want: "unterminated quoted string"},
+	}
+	for _, tt := range errs {
+		var spec checkSpec
+		err := p.Decode(tt.tag, &spec)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.tag, err, tt.want)
+		}
+	}
+
+	if err := p.Decode("min:1", spec); err == nil {
+		t.Error("expected an error for a non-pointer")
+	}
+}
+
+func TestParser_DecodeCaseFold(t *testing.T) {
+	p := NewParser("check")
+	tests := []struct {
+		tag  string
+		want int
+	}{
+		{"min:1; MIN:2; mIn:3", 2},
+		{"mIn:3; MIN:2; Min:1", 1},
+		{"mIn:3; MIN:2", 2},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			var spec checkSpec
+			if err := p.Decode(tt.tag, &spec); err != nil {
+				t.Fatal(err)
+			}
+			if spec.Min != tt.want {
+				t.Fatalf("%s: got Min %d, want %d", tt.tag, spec.Min, tt.want)
+			}
+		}
+	}
+}
+
+func TestFieldMeta_Decode(t *testing.T) {
+	type Form struct {
+		Name string `check:"min:2; roles:admin"`
+	}
+
+	fields := NewParser("check").ParseStruct(&Form{})
+	var spec checkSpec
+	if err := fields[0].Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Min != 2 || len(spec.Roles) != 1 || spec.Optional {
+		t.Errorf("got %+v", spec)
+	}
+
+	typ := reflect.TypeOf(spec)
+	specMu.RLock()
+	_, cached := specCache[typ]
+	specMu.RUnlock()
+	if !cached {
+		t.Error("spec fields not cached")
+	}
+}
@@ -0,0 +1,99 @@
+package tags
+
+import (
+	"slices"
+	"strings"
+)
+
+// Format is the inverse of Parse: it writes the pairs of m with the
+// delimiters of the parser, keys sorted, so that equal maps always format
+// the same. Keys with nil or empty values are written alone. Keys and
+// values that would not parse back as written are double-quoted.
+//
+// Example:
+//
+//	p.Format(map[string][]string{"read": {"admin", "user"}, "url": {"http://x"}})
+//	// read:admin,user; url:http://x
+func (p *Parser) Format(m map[string][]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	sep := p.pairDelimiter
+	if strings.TrimSpace(sep) == sep {
+		sep += " "
+	}
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteString(p.quote(k, p.pairDelimiter, p.kvSeparator))
+		if len(m[k]) == 0 {
+			continue
+		}
+		sb.WriteString(p.kvSeparator)
+		for j, v := range m[k] {
+			if j > 0 {
+				sb.WriteString(p.valueDelim)
+			}
+			sb.WriteString(p.quote(v, p.pairDelimiter, p.valueDelim))
+		}
+	}
+	return sb.String()
+}
+
+// Canonical rewrites a tag in the form Format produces. The tag is checked
+// with ParseStrict first, and its errors are returned as is.
+func (p *Parser) Canonical(tag string) (string, error) {
+	m, err := p.ParseStrict(tag)
+	if err != nil {
+		return "", err
+	}
+	return p.Format(m), nil
+}
+
+// quote returns s as written in a tag where stop1 and stop2 end it.
+func (p *Parser) quote(s, stop1, stop2 string) string {
+	if !p.needsQuote(s, stop1, stop2) {
+		return s
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		if r == '"' || r == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// needsQuote reports whether s must be quoted to parse back as written,
+// followed by stop1 or stop2. Backslashes always need quotes: outside
+// them, whether one escapes depends on what follows.
+func (p *Parser) needsQuote(s, stop1, stop2 string) bool {
+	switch {
+	case s == "", s != strings.TrimSpace(s), s[0] == '"', s[0] == '\'':
+		return true
+	case strings.Contains(s, `\`):
+		return true
+	}
+	// A stop must not start inside s, even where the end of s and the
+	// delimiter written after it overlap.
+	for _, stop := range []string{stop1, stop2} {
+		if stop == "" {
+			continue
+		}
+		for _, next := range []string{stop1, stop2} {
+			if i := strings.Index(s+next, stop); i >= 0 && i < len(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
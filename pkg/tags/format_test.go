@@ -0,0 +1,124 @@
+package tags
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParser_Format(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		in   map[string][]string
+		want string
+	}{
+		{
+			name: "sorted keys",
+			in:   map[string][]string{"role": {"owner"}, "read": {"admin", "user"}, "required": nil},
+			want: "read:admin,user; required; role:owner",
+		},
+		{
+			name: "quoting",
+			in: map[string][]string{
+				"default": {"a,b", ""},
+				"layout":  {"15:04"},
+				"pad":     {" x "},
+				"quote":   {`say "hi"`, "'x"},
+				"regex":   {`^\d+$`, `a\;b`},
+				"a;b":     nil,
+			},
+			want: `"a;b"; default:"a,b",""; layout:15:04; pad:" x "; quote:say "hi","'x"; regex:"^\\d+$","a\\;b"`,
+		},
+		{
+			name: "conf style",
+			opts: []Option{WithPairDelimiter(",")},
+			in:   map[string][]string{"env": {"PORT"}, "default": {"a,b"}},
+			want: `default:"a,b", env:PORT`,
+		},
+		{
+			name: "trailing backslash",
+			in:   map[string][]string{"dir": {`C\`, "D"}, "z": nil},
+			want: `dir:"C\\",D; z`,
+		},
+		{
+			name: "empty",
+			in:   map[string][]string{},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser("test", tt.opts...)
+			got := p.Format(tt.in)
+			if got != tt.want {
+				t.Errorf("Format: got %s, want %s", got, tt.want)
+			}
+			back, err := p.ParseStrict(got)
+			if err != nil {
+				t.Fatalf("ParseStrict(%s): %v", got, err)
+			}
+			if !reflect.DeepEqual(back, tt.in) {
+				t.Errorf("round trip: got %q, want %q", back, tt.in)
+			}
+		})
+	}
+}
+
+func TestParser_Canonical(t *testing.T) {
+	p := NewParser("guard", WithAllowedKeys("role", "read"))
+
+	got, err := p.Canonical(`  role : owner ;read:'admin' ,  user;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "read:admin,user; role:owner"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := p.Canonical("rloe:owner"); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
+
+func TestParser_FormatRoundTrip(t *testing.T) {
+	// With the same pair and value delimiter, keys take a single value.
+	configs := []struct {
+		name   string
+		opts   []Option
+		single bool
+	}{
+		{"default", nil, false},
+		{"conf style", []Option{WithPairDelimiter(",")}, true},
+		{"space pairs", []Option{WithPairDelimiter(" "), WithKVSeparator("=")}, false},
+		{"space values", []Option{WithValueDelimiter(" ")}, false},
+		{"space separator", []Option{WithKVSeparator(" ")}, false},
+		{"all spaces", []Option{WithPairDelimiter(" "), WithKVSeparator("="), WithValueDelimiter(" ")}, true},
+		{"long delimiters", []Option{WithPairDelimiter("&&"), WithKVSeparator("=>"), WithValueDelimiter("||")}, false},
+	}
+	edge := []string{
+		"x", `C\`, `\`, `\\`, `a\;b`, `\"`, `"`, `'`, `x'`, `x"y`, ";", ",", ":", "=",
+		" ", "\t", "a b", " a", "a ", "&", "x&", "&x", "|", "x|", "=>", "x=", ">x", "é", "a,b;c:d",
+	}
+	for _, c := range configs {
+		p := NewParser("test", c.opts...)
+		for i, v := range edge {
+			m := map[string][]string{
+				v:       nil,
+				"k":     {v},
+				"multi": {v, edge[(i+1)%len(edge)], v},
+				"last":  {"y", v},
+			}
+			if c.single {
+				m = map[string][]string{v: nil, "k": {v}, "next": {edge[(i+1)%len(edge)]}}
+			}
+			got := p.Format(m)
+			if back := p.Parse(got); !reflect.DeepEqual(back, m) {
+				t.Errorf("%s: Parse(Format(%q)) = %q via %s", c.name, m, back, got)
+			}
+			if back, err := p.ParseStrict(got); err != nil || !reflect.DeepEqual(back, m) {
+				t.Errorf("%s: ParseStrict(Format(%q)) = %q, %v via %s", c.name, m, back, err, got)
+			}
+		}
+	}
+}
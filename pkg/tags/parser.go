@@ -2,7 +2,7 @@ package tags
 
 import (
 	"reflect"
-	"strings"
+	"slices"
 	"sync"
 )
 
@@ -17,6 +17,11 @@ type Parser struct {
 	pairDelimiter string
 	kvSeparator   string
 	valueDelim    string
+	allowed       map[string]bool
+	embedded      bool
+	nested        bool
+	extraTags     []string
+	aliases       map[string][]string
 	cache         map[reflect.Type][]FieldMeta
 	mu            sync.RWMutex
 }
@@ -39,6 +44,33 @@ func WithValueDelimiter(d string) Option {
 	return func(p *Parser) { p.valueDelim = d }
 }
 
+// WithAllowedKeys restricts the keys accepted by ParseStrict and
+// ParseStructStrict. Parse ignores it.
+func WithAllowedKeys(keys ...string) Option {
+	return func(p *Parser) {
+		p.allowed = make(map[string]bool, len(keys))
+		for _, k := range keys {
+			p.allowed[k] = true
+		}
+	}
+}
+
+// WithEmbedded makes ParseType include the fields promoted from embedded
+// structs, following Go's shadowing rules: a field hides deeper fields of
+// the same name, and fields of the same name at the same depth hide each
+// other. Fields promoted through an embedded pointer are only reachable
+// once the pointer is set; see reflect.Value.FieldByIndexErr.
+func WithEmbedded() Option {
+	return func(p *Parser) { p.embedded = true }
+}
+
+// WithNested makes ParseType descend into named struct fields, reporting
+// their tagged fields with dotted paths such as "Database.Host". Fields of
+// pointer type are not followed.
+func WithNested() Option {
+	return func(p *Parser) { p.nested = true }
+}
+
 // NewParser creates a Parser for the given tag name.
 func NewParser(tagName string, opts ...Option) *Parser {
 	p := &Parser{
@@ -54,42 +86,37 @@ func NewParser(tagName string, opts ...Option) *Parser {
 	return p
 }
 
-// Parse extracts key-value pairs from a tag string.
+// Parse extracts key-value pairs from a tag string. Later pairs replace
+// earlier ones with the same key. Quoting and escapes are described in
+// Tokenize; malformed input is parsed up to the error.
 func (p *Parser) Parse(tag string) map[string][]string {
 	result := make(map[string][]string)
-
-	for part := range strings.SplitSeq(tag, p.pairDelimiter) {
-		part = strings.TrimSpace(part)
-		if part == "" {
-			continue
-		}
-
-		key, value, found := strings.Cut(part, p.kvSeparator)
-		key = strings.TrimSpace(key)
-
-		if !found {
-			result[key] = nil
-			continue
-		}
-
-		value = strings.TrimSpace(value)
-		var values []string
-		for v := range strings.SplitSeq(value, p.valueDelim) {
-			values = append(values, strings.TrimSpace(v))
-		}
-		result[key] = values
+	pairs, _ := p.Tokenize(tag)
+	for _, pair := range pairs {
+		result[pair.Key] = pair.Values
 	}
-
 	return result
 }
 
 // FieldMeta holds parsed tag metadata for a struct field.
+//
+// Index is the position of the field in the struct declaring it, and
+// IndexPath locates it from the parsed type for reflect.Value.FieldByIndex.
+// Path is the dotted name of the field, such as "Database.Host" for a
+// nested field; promoted fields keep their own name.
+//
+// Tags and RawTag come from the tag name of the parser, or its alias.
+// ByTag holds every tag read by the parser, keyed by canonical name; with
+// WithTags, fields carrying only other tags have empty Tags.
 type FieldMeta struct {
 	Name       string
+	Path       string
 	Index      int
+	IndexPath  []int
 	Type       reflect.Type
 	Tags       map[string][]string
 	RawTag     string
+	ByTag      map[string]TagMeta
 	IsExported bool
 }
 
@@ -105,7 +132,9 @@ func (p *Parser) ParseStruct(v any) []FieldMeta {
 	return p.ParseType(val.Type())
 }
 
-// ParseType extracts tag metadata from a reflect.Type.
+// ParseType extracts tag metadata from a reflect.Type. Only its direct
+// fields are included unless WithEmbedded or WithNested is set. Results are
+// cached per type.
 func (p *Parser) ParseType(typ reflect.Type) []FieldMeta {
 	if typ.Kind() == reflect.Ptr {
 		typ = typ.Elem()
@@ -129,26 +158,51 @@ func (p *Parser) ParseType(typ reflect.Type) []FieldMeta {
 		return cached
 	}
 
-	var fields []FieldMeta
+	fields := p.walk(nil, typ, nil, "", true)
 
-	for i := 0; i < typ.NumField(); i++ {
-		field := typ.Field(i)
-		tag := field.Tag.Get(p.tagName)
-		if tag == "" {
-			continue
-		}
+	p.cache[typ] = fields
+	return fields
+}
 
-		fields = append(fields, FieldMeta{
-			Name:       field.Name,
-			Index:      i,
-			Type:       field.Type,
-			Tags:       p.Parse(tag),
-			RawTag:     tag,
-			IsExported: field.IsExported(),
-		})
+// walk appends the tagged fields of typ to fields. index, path and
+// exported describe the struct field typ was reached through, if any.
+func (p *Parser) walk(fields []FieldMeta, typ reflect.Type, index []int, path string, exported bool) []FieldMeta {
+	var visible []reflect.StructField
+	if p.embedded {
+		visible = reflect.VisibleFields(typ)
+	} else {
+		for i := 0; i < typ.NumField(); i++ {
+			visible = append(visible, typ.Field(i))
+		}
 	}
 
-	p.cache[typ] = fields
+	for _, field := range visible {
+		fieldIndex := append(slices.Clip(index), field.Index...)
+		fieldPath := field.Name
+		if path != "" {
+			fieldPath = path + "." + field.Name
+		}
+		isExported := exported && field.IsExported()
+
+		if byTag := p.fieldTags(field); byTag != nil {
+			primary := byTag[p.tagName]
+			fields = append(fields, FieldMeta{
+				Name:       field.Name,
+				Path:       fieldPath,
+				Index:      field.Index[len(field.Index)-1],
+				IndexPath:  fieldIndex,
+				Type:       field.Type,
+				Tags:       primary.Tags,
+				RawTag:     primary.Raw,
+				ByTag:      byTag,
+				IsExported: isExported,
+			})
+		}
+
+		if p.nested && !field.Anonymous && field.Type.Kind() == reflect.Struct {
+			fields = p.walk(fields, field.Type, fieldIndex, fieldPath, isExported)
+		}
+	}
 	return fields
 }
 
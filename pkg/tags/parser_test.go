@@ -168,3 +168,79 @@ func TestFieldMeta_Methods(t *testing.T) {
 		t.Error("Has missing: should be false")
 	}
 }
+
+type embeddedBase struct {
+	ID    int    `db:"id"`
+	Name  string `db:"base_name"`
+	Extra string `db:"extra"`
+}
+
+type embeddedOther struct {
+	Extra string `db:"other_extra"`
+}
+
+type embeddedDatabase struct {
+	Host string `db:"host"`
+	Port int    `db:"port"`
+}
+
+type embeddedModel struct {
+	embeddedBase
+	*embeddedOther
+	Name     string           `db:"name"`
+	Database embeddedDatabase `db:"database"`
+}
+
+func TestParser_ParseTypeEmbedded(t *testing.T) {
+	p := NewParser("db", WithEmbedded())
+	fields := p.ParseType(reflect.TypeOf(embeddedModel{}))
+
+	// Name shadows embeddedBase.Name and the two Extra fields are
+	// ambiguous, as in Go.
+	want := []struct {
+		path  string
+		index []int
+	}{
+		{"ID", []int{0, 0}},
+		{"Name", []int{2}},
+		{"Database", []int{3}},
+	}
+	if len(fields) != len(want) {
+		t.Fatalf("got %d fields, want %d: %+v", len(fields), len(want), fields)
+	}
+	v := reflect.ValueOf(embeddedModel{embeddedBase: embeddedBase{ID: 7}})
+	for i, w := range want {
+		if fields[i].Path != w.path || !reflect.DeepEqual(fields[i].IndexPath, w.index) {
+			t.Errorf("field %d: got %s %v, want %s %v", i, fields[i].Path, fields[i].IndexPath, w.path, w.index)
+		}
+	}
+	if got := v.FieldByIndex(fields[0].IndexPath).Int(); got != 7 {
+		t.Errorf("FieldByIndex: got %d, want 7", got)
+	}
+	if fields[0].Index != 0 {
+		t.Errorf("Index: got %d, want 0", fields[0].Index)
+	}
+}
+
+func TestParser_ParseTypeNested(t *testing.T) {
+	p := NewParser("db", WithNested())
+	fields := p.ParseType(reflect.TypeOf(embeddedModel{}))
+
+	var paths []string
+	for _, f := range fields {
+		paths = append(paths, f.Path)
+	}
+	want := []string{"Name", "Database", "Database.Host", "Database.Port"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("got %v, want %v", paths, want)
+	}
+	host := fields[2]
+	if host.Name != "Host" || !reflect.DeepEqual(host.IndexPath, []int{3, 0}) || host.Get("host") != "" || !host.Has("host") {
+		t.Errorf("got %+v", host)
+	}
+
+	// Without options only direct fields are parsed.
+	if n := len(NewParser("db").ParseType(reflect.TypeOf(embeddedModel{}))); n != 2 {
+		t.Errorf("got %d direct fields, want 2", n)
+	}
+}
@@ -0,0 +1,145 @@
+package tags
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	// ErrDuplicateKey is reported when a key appears twice in a tag.
+	ErrDuplicateKey = errors.New("tags: duplicate key")
+	// ErrUnknownKey is reported for keys outside WithAllowedKeys.
+	ErrUnknownKey = errors.New("tags: unknown key")
+	// ErrTagConflict is reported when a field carries a tag under more
+	// than one of its names.
+	ErrTagConflict = errors.New("tags: conflicting tag")
+)
+
+// KeyError reports a well-formed key that strict parsing rejects.
+type KeyError struct {
+	Tag    string
+	Offset int
+	Key    string
+	Err    error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%v %q at offset %d in %q", e.Err, e.Key, e.Offset, e.Tag)
+}
+
+func (e *KeyError) Unwrap() error { return e.Err }
+
+// FieldError locates a strict parsing error in a struct field tag. Field
+// is the dotted path of the field and Column the 1-based byte column of
+// the error in the tag value.
+type FieldError struct {
+	Type    reflect.Type
+	Field   string
+	TagName string
+	Column  int
+	Err     error
+}
+
+func (e *FieldError) Error() string {
+	msg := strings.TrimPrefix(e.Err.Error(), "tags: ")
+	var se *SyntaxError
+	var ke *KeyError
+	switch {
+	case errors.As(e.Err, &se):
+		msg = se.Msg
+	case errors.As(e.Err, &ke):
+		msg = fmt.Sprintf("%s %q", strings.TrimPrefix(ke.Err.Error(), "tags: "), ke.Key)
+	}
+	return fmt.Sprintf("tags: %s.%s: %s tag column %d: %s", e.Type, e.Field, e.TagName, e.Column, msg)
+}
+
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// ParseStrict is like Parse but rejects malformed input, empty keys and
+// values that are not quoted, stray delimiters, duplicate keys and, when
+// WithAllowedKeys is set, unknown keys. Errors are a *SyntaxError or a
+// *KeyError.
+func (p *Parser) ParseStrict(tag string) (map[string][]string, error) {
+	return p.parseStrict(tag, p.allowed)
+}
+
+func (p *Parser) parseStrict(tag string, allowed map[string]bool) (map[string][]string, error) {
+	pairs, err := p.tokenize(tag, true)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]string, len(pairs))
+	for _, pair := range pairs {
+		if _, ok := result[pair.Key]; ok {
+			return nil, &KeyError{Tag: tag, Offset: pair.Offset, Key: pair.Key, Err: ErrDuplicateKey}
+		}
+		if allowed != nil && !allowed[pair.Key] {
+			return nil, &KeyError{Tag: tag, Offset: pair.Offset, Key: pair.Key, Err: ErrUnknownKey}
+		}
+		result[pair.Key] = pair.Values
+	}
+	return result, nil
+}
+
+// ParseStructStrict is like ParseStruct but checks every tag with
+// ParseStrict. It returns the fields along with a *FieldError for each
+// invalid tag, joined with errors.Join. The allow-list only applies to the
+// tag name of the parser. Aliases shadowed by another spelling of their
+// tag are reported with ErrTagConflict.
+func (p *Parser) ParseStructStrict(v any) ([]FieldMeta, error) {
+	typ := reflect.TypeOf(v)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("tags: %T is not a struct", v)
+	}
+
+	fields := p.ParseType(typ)
+	var errs []error
+	for _, f := range fields {
+		for _, name := range p.tagNames() {
+			tm, ok := f.ByTag[name]
+			if !ok {
+				continue
+			}
+			var allowed map[string]bool
+			if name == p.tagName {
+				allowed = p.allowed
+			}
+			if _, err := p.parseStrict(tm.Raw, allowed); err != nil {
+				errs = append(errs, &FieldError{
+					Type:    typ,
+					Field:   f.Path,
+					TagName: tm.Source,
+					Column:  errorOffset(err) + 1,
+					Err:     err,
+				})
+			}
+			for _, source := range tm.Shadowed {
+				errs = append(errs, &FieldError{
+					Type:    typ,
+					Field:   f.Path,
+					TagName: source,
+					Column:  1,
+					Err:     fmt.Errorf("%w: %s takes precedence", ErrTagConflict, tm.Source),
+				})
+			}
+		}
+	}
+	return fields, errors.Join(errs...)
+}
+
+func errorOffset(err error) int {
+	var se *SyntaxError
+	var ke *KeyError
+	switch {
+	case errors.As(err, &se):
+		return se.Offset
+	case errors.As(err, &ke):
+		return ke.Offset
+	}
+	return 0
+}
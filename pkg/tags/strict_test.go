@@ -0,0 +1,81 @@
+package tags
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParser_ParseStrict(t *testing.T) {
+	p := NewParser("guard", WithAllowedKeys("role", "read", "write"))
+
+	got, err := p.ParseStrict(`role:owner; read:admin,""; write:'a,b';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"role": {"owner"}, "read": {"admin", ""}, "write": {"a,b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		input  string
+		offset int
+		target error
+	}{
+		{input: "rloe:owner", offset: 0, target: ErrUnknownKey},
+		{input: "role:a; read:b; role:c", offset: 16, target: ErrDuplicateKey},
+		{input: "role:a;; read:b", offset: 7},
+		{input: "; role:a", offset: 0},
+		{input: ":a", offset: 0},
+		{input: "role:", offset: 5},
+		{input: "read:a,,b", offset: 7},
+		{input: `role:"a`, offset: 5},
+	}
+	for _, tt := range tests {
+		_, err := p.ParseStrict(tt.input)
+		if err == nil {
+			t.Errorf("%s: no error", tt.input)
+			continue
+		}
+		if tt.target != nil && !errors.Is(err, tt.target) {
+			t.Errorf("%s: got %v, want %v", tt.input, err, tt.target)
+		}
+		if off := errorOffset(err); off != tt.offset {
+			t.Errorf("%s: offset %d, want %d (%v)", tt.input, off, tt.offset, err)
+		}
+	}
+
+	// Parse keeps accepting the same input.
+	if got := p.Parse("rloe:owner;; role:"); len(got) != 2 {
+		t.Errorf("Parse: got %q", got)
+	}
+}
+
+func TestParser_ParseStructStrict(t *testing.T) {
+	type Doc struct {
+		Owner  string `guard:"role:owner"`
+		Typo   string `guard:"role:owner; rloe:admin"`
+		Broken string `guard:"read:'x"`
+	}
+
+	p := NewParser("guard", WithAllowedKeys("role", "read"))
+	fields, err := p.ParseStructStrict(&Doc{})
+	if len(fields) != 3 {
+		t.Errorf("got %d fields, want 3", len(fields))
+	}
+
+	var fe *FieldError
+	if !errors.As(err, &fe) || fe.Field != "Typo" || fe.Column != 13 || !errors.Is(fe, ErrUnknownKey) {
+		t.Fatalf("got %v", err)
+	}
+	const want = `tags: tags.Doc.Typo: guard tag column 13: unknown key "rloe"` + "\n" +
+		`tags: tags.Doc.Broken: guard tag column 6: unterminated quoted string`
+	if err.Error() != want {
+		t.Errorf("got\n%s\nwant\n%s", err, want)
+	}
+
+	if _, err := p.ParseStructStrict(42); err == nil {
+		t.Error("expected an error for a non-struct")
+	}
+}
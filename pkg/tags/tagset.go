@@ -0,0 +1,85 @@
+package tags
+
+import (
+	"reflect"
+	"slices"
+)
+
+// TagMeta holds the parsed value of one tag name on a field.
+type TagMeta struct {
+	// Name is the canonical tag name.
+	Name string
+	// Source is the tag actually read: Name or one of its aliases.
+	Source string
+	Raw    string
+	Tags   map[string][]string
+	// Shadowed lists the other spellings of the tag present on the field,
+	// which Source took precedence over.
+	Shadowed []string
+}
+
+// WithTags makes ParseType read the given tag names along with the tag
+// name of the parser, in one pass. Fields carrying any of them are
+// returned, with every tag in FieldMeta.ByTag.
+func WithTags(names ...string) Option {
+	return func(p *Parser) {
+		for _, name := range names {
+			p.addTag(name)
+		}
+	}
+}
+
+// WithAlias declares aliases for the tag name, such as a short spelling
+// being phased out. The canonical name takes precedence, then the aliases
+// in the given order; the spellings that lose are recorded in
+// TagMeta.Shadowed and reported by ParseStructStrict. name is read by
+// ParseType even if it was not passed to WithTags.
+func WithAlias(name string, aliases ...string) Option {
+	return func(p *Parser) {
+		p.addTag(name)
+		if p.aliases == nil {
+			p.aliases = make(map[string][]string)
+		}
+		p.aliases[name] = append(p.aliases[name], aliases...)
+	}
+}
+
+func (p *Parser) addTag(name string) {
+	if name != p.tagName && !slices.Contains(p.extraTags, name) {
+		p.extraTags = append(p.extraTags, name)
+	}
+}
+
+// tagNames returns the canonical tag names read by the parser, its own
+// first.
+func (p *Parser) tagNames() []string {
+	return append([]string{p.tagName}, p.extraTags...)
+}
+
+// fieldTags reads every tag of the parser on field, keyed by canonical
+// name.
+func (p *Parser) fieldTags(field reflect.StructField) map[string]TagMeta {
+	var byTag map[string]TagMeta
+	for _, name := range p.tagNames() {
+		var meta TagMeta
+		for _, source := range append([]string{name}, p.aliases[name]...) {
+			raw := field.Tag.Get(source)
+			if raw == "" {
+				continue
+			}
+			if meta.Source != "" {
+				meta.Shadowed = append(meta.Shadowed, source)
+				continue
+			}
+			meta = TagMeta{Name: name, Source: source, Raw: raw, Tags: p.Parse(raw)}
+		}
+		if meta.Source == "" {
+			continue
+		}
+		if byTag == nil {
+			byTag = make(map[string]TagMeta)
+		}
+		byTag[name] = meta
+	}
+	return byTag
+}
@@ -0,0 +1,77 @@
+package tags
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParser_WithTagsAndAliases(t *testing.T) {
+	type Doc struct {
+		Owner   string `guard:"role:owner" json:"owner"`
+		Legacy  string `g:"role:admin"`
+		Both    string `guard:"role:a" g:"role:b"`
+		Cached  string `cache:"ttl:60"`
+		Ignored string `json:"ignored"`
+	}
+
+	p := NewParser("guard", WithAlias("guard", "g"), WithTags("cache"))
+	fields := p.ParseStruct(&Doc{})
+
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.Name)
+	}
+	if want := []string{"Owner", "Legacy", "Both", "Cached"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got %v, want %v", names, want)
+	}
+
+	legacy := fields[1]
+	if legacy.Get("role") != "admin" || legacy.ByTag["guard"].Source != "g" {
+		t.Errorf("alias: got %+v", legacy.ByTag)
+	}
+
+	both := fields[2]
+	if both.Get("role") != "a" || !reflect.DeepEqual(both.ByTag["guard"].Shadowed, []string{"g"}) {
+		t.Errorf("precedence: got %+v", both.ByTag)
+	}
+
+	cached := fields[3]
+	if cached.Tags != nil || cached.ByTag["cache"].Tags["ttl"][0] != "60" {
+		t.Errorf("extra tag: got %+v", cached)
+	}
+
+	// One cached walk serves every tag name.
+	if again := p.ParseStruct(&Doc{}); &again[0] != &fields[0] {
+		t.Error("fields not cached")
+	}
+
+	_, err := p.ParseStructStrict(&Doc{})
+	var fe *FieldError
+	if !errors.Is(err, ErrTagConflict) || !errors.As(err, &fe) || fe.Field != "Both" || fe.TagName != "g" {
+		t.Fatalf("got %v", err)
+	}
+	const want = "tags: tags.Doc.Both: g tag column 1: conflicting tag: guard takes precedence"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
+
+func TestParser_AliasOfExtraTag(t *testing.T) {
+	type Doc struct {
+		Field string `v:"min:1" check:"role:x"`
+	}
+
+	p := NewParser("check", WithAlias("validate", "v"))
+	fields := p.ParseStruct(&Doc{})
+	if len(fields) != 1 {
+		t.Fatalf("got %d fields, want 1", len(fields))
+	}
+	tm := fields[0].ByTag["validate"]
+	if tm.Name != "validate" || tm.Source != "v" || tm.Tags["min"][0] != "1" {
+		t.Errorf("got %+v", tm)
+	}
+	if fields[0].Get("role") != "x" {
+		t.Errorf("primary tag: got %v", fields[0].Tags)
+	}
+}
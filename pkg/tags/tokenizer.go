@@ -0,0 +1,241 @@
+package tags
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pair is a key and its values as written in a tag.
+type Pair struct {
+	Key string
+	// Values is nil when the key has no separator.
+	Values []string
+	// Offset is the byte offset of the key in the tag.
+	Offset int
+}
+
+// SyntaxError reports malformed tag input.
+type SyntaxError struct {
+	Tag    string
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("tags: %s at offset %d in %q", e.Msg, e.Offset, e.Tag)
+}
+
+// Tokenize splits a tag into pairs in source order.
+//
+// Keys and values may be wrapped in single or double quotes, inside which
+// delimiters and spaces are literal and a backslash escapes the next
+// character. Outside quotes, a backslash escapes a delimiter, a quote or
+// another backslash and is kept literally before anything else. Quotes
+// only open a quoted string at the start of a key or value.
+//
+// On malformed input Tokenize returns the pairs read so far and a
+// *SyntaxError.
+func (p *Parser) Tokenize(tag string) ([]Pair, error) {
+	return p.tokenize(tag, false)
+}
+
+// tokenize implements Tokenize. In strict mode, empty pairs, keys and
+// values that are not quoted are errors; a single trailing pair delimiter
+// is allowed.
+func (p *Parser) tokenize(tag string, strict bool) ([]Pair, error) {
+	t := &tokenizer{p: p, tag: tag}
+	var pairs []Pair
+	for t.pos < len(tag) {
+		t.skipSpace()
+		offset := t.pos
+		key, quoted, stop, err := t.item(p.pairDelimiter, p.kvSeparator)
+		if err != nil {
+			return pairs, err
+		}
+		if key == "" && !quoted && strict {
+			if stop == stopSecond {
+				return pairs, t.errorf(offset, "empty key")
+			}
+			if stop == stopFirst {
+				return pairs, t.errorf(offset, "stray %q", p.pairDelimiter)
+			}
+		}
+		if stop != stopSecond {
+			if key != "" || quoted {
+				pairs = append(pairs, Pair{Key: key, Offset: offset})
+			}
+			continue
+		}
+
+		pair := Pair{Key: key, Values: []string{}, Offset: offset}
+		t.skipSpace(p.pairDelimiter)
+		for {
+			offset := t.pos
+			value, quoted, stop, err := t.item(p.pairDelimiter, p.valueDelim)
+			if err != nil {
+				return pairs, err
+			}
+			if value == "" && !quoted && strict {
+				return pairs, t.errorf(offset, "empty value for key %q", key)
+			}
+			pair.Values = append(pair.Values, value)
+			if stop != stopSecond {
+				break
+			}
+			t.skipSpace(p.pairDelimiter, p.valueDelim)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+type tokenizer struct {
+	p   *Parser
+	tag string
+	pos int
+}
+
+// at reports whether the input at the current position starts with d.
+func (t *tokenizer) at(d string) bool {
+	return d != "" && strings.HasPrefix(t.tag[t.pos:], d)
+}
+
+// skipSpace skips white space up to the first of stops, which may be
+// white space themselves.
+func (t *tokenizer) skipSpace(stops ...string) {
+	for t.pos < len(t.tag) {
+		for _, d := range stops {
+			if t.at(d) {
+				return
+			}
+		}
+		r, size := utf8.DecodeRuneInString(t.tag[t.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		t.pos += size
+	}
+}
+
+// trailing reports whether only white space is left before the pair
+// delimiter or the end of the tag.
+func (t *tokenizer) trailing() bool {
+	for i := t.pos; i < len(t.tag); {
+		if d := t.p.pairDelimiter; d != "" && strings.HasPrefix(t.tag[i:], d) {
+			return true
+		}
+		r, size := utf8.DecodeRuneInString(t.tag[i:])
+		if !unicode.IsSpace(r) {
+			return false
+		}
+		i += size
+	}
+	return true
+}
+
+// Delimiters ending an item.
+const (
+	stopEnd = iota
+	stopFirst
+	stopSecond
+)
+
+// item reads a key or value up to one of the two stop delimiters or the
+// end of the tag, consuming the delimiter. It returns whether the item was
+// quoted and which delimiter ended it; stop1 wins when both match. Like
+// the rest of the pair, the item is trimmed of white space, so a white
+// space stop2 only counts before something other than stop1.
+func (t *tokenizer) item(stop1, stop2 string) (string, bool, int, error) {
+	var sb strings.Builder
+	quoted := false
+
+	if t.pos < len(t.tag) && (t.tag[t.pos] == '"' || t.tag[t.pos] == '\'') {
+		if err := t.quoted(&sb); err != nil {
+			return "", false, stopEnd, err
+		}
+		quoted = true
+		t.skipSpace(stop1, stop2)
+		if t.pos < len(t.tag) && !t.at(stop1) && !t.at(stop2) {
+			return "", false, stopEnd, t.errorf(t.pos, "unexpected character after quoted string")
+		}
+	}
+
+	for t.pos < len(t.tag) {
+		if t.at(stop1) || t.at(stop2) {
+			break
+		}
+		if t.tag[t.pos] == '\\' && t.escapable(t.pos+1) {
+			t.pos++
+		}
+		r, size := utf8.DecodeRuneInString(t.tag[t.pos:])
+		sb.WriteRune(r)
+		t.pos += size
+	}
+	if !t.at(stop1) && t.at(stop2) && t.trailing() {
+		t.skipSpace(stop1)
+	}
+
+	stop := stopEnd
+	switch {
+	case t.at(stop1):
+		stop = stopFirst
+		t.pos += len(stop1)
+	case t.at(stop2):
+		stop = stopSecond
+		t.pos += len(stop2)
+	}
+
+	if quoted {
+		return sb.String(), true, stop, nil
+	}
+	return strings.TrimSpace(sb.String()), false, stop, nil
+}
+
+// quoted reads a quoted string starting at the current position.
+func (t *tokenizer) quoted(sb *strings.Builder) error {
+	start := t.pos
+	quote := t.tag[t.pos]
+	t.pos++
+	for t.pos < len(t.tag) {
+		c := t.tag[t.pos]
+		switch {
+		case c == quote:
+			t.pos++
+			return nil
+		case c == '\\':
+			if t.pos+1 == len(t.tag) {
+				return t.errorf(t.pos, "unterminated escape sequence")
+			}
+			t.pos++
+		}
+		r, size := utf8.DecodeRuneInString(t.tag[t.pos:])
+		sb.WriteRune(r)
+		t.pos += size
+	}
+	return t.errorf(start, "unterminated quoted string")
+}
+
+// escapable reports whether a backslash before position i escapes it
+// outside quotes.
+func (t *tokenizer) escapable(i int) bool {
+	if i >= len(t.tag) {
+		return false
+	}
+	switch t.tag[i] {
+	case '\\', '"', '\'':
+		return true
+	}
+	rest := t.tag[i:]
+	for _, d := range []string{t.p.pairDelimiter, t.p.kvSeparator, t.p.valueDelim} {
+		if d != "" && strings.HasPrefix(rest, d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tokenizer) errorf(offset int, format string, args ...any) error {
+	return &SyntaxError{Tag: t.tag, Offset: offset, Msg: fmt.Sprintf(format, args...)}
+}
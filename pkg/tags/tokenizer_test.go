@@ -0,0 +1,213 @@
+package tags
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParser_ParseQuoted(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		input    string
+		expected map[string][]string
+	}{
+		{
+			name:  "double quoted url",
+			input: `url:"http://example.com/a;b"; retries:3`,
+			expected: map[string][]string{
+				"url":     {"http://example.com/a;b"},
+				"retries": {"3"},
+			},
+		},
+		{
+			name:  "single quoted values keep spaces and commas",
+			input: `default:' a, b ', "c"; layout:'15:04:05'`,
+			expected: map[string][]string{
+				"default": {" a, b ", "c"},
+				"layout":  {"15:04:05"},
+			},
+		},
+		{
+			name:  "escapes inside quotes",
+			input: `pattern:"^\\d+\"x\"$"`,
+			expected: map[string][]string{
+				"pattern": {`^\d+"x"$`},
+			},
+		},
+		{
+			name:  "escaped delimiters outside quotes",
+			input: `sep:\;\,\:; quote:\"`,
+			expected: map[string][]string{
+				"sep":   {";,:"},
+				"quote": {`"`},
+			},
+		},
+		{
+			name:  "other backslashes are literal",
+			input: `pattern:^\d+\.\w$`,
+			expected: map[string][]string{
+				"pattern": {`^\d+\.\w$`},
+			},
+		},
+		{
+			name:  "quotes inside a value are literal",
+			input: `name:O'Brien`,
+			expected: map[string][]string{
+				"name": {"O'Brien"},
+			},
+		},
+		{
+			name:  "quoted key",
+			input: `"a;b":x; ""`,
+			expected: map[string][]string{
+				"a;b": {"x"},
+				"":    nil,
+			},
+		},
+		{
+			name:  "same pair and value delimiter",
+			opts:  []Option{WithPairDelimiter(",")},
+			input: `default:"a,b",env:X`,
+			expected: map[string][]string{
+				"default": {"a,b"},
+				"env":     {"X"},
+			},
+		},
+		{
+			name:  "space pair delimiter after quotes",
+			opts:  []Option{WithPairDelimiter(" "), WithKVSeparator("=")},
+			input: `a="q" b=2 c='x y' `,
+			expected: map[string][]string{
+				"a": {"q"},
+				"b": {"2"},
+				"c": {"x y"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser("test", tt.opts...)
+			if _, err := p.Tokenize(tt.input); err != nil {
+				t.Fatalf("Tokenize: %v", err)
+			}
+			if got := p.Parse(tt.input); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParser_TokenizeErrors(t *testing.T) {
+	tests := []struct {
+		input  string
+		offset int
+		pairs  int
+	}{
+		{input: `a:1; b:"unterminated`, offset: 7, pairs: 1},
+		{input: `a:'x' y`, offset: 6, pairs: 0},
+		{input: `a:"x\`, offset: 4, pairs: 0},
+	}
+
+	p := NewParser("test")
+	for _, tt := range tests {
+		pairs, err := p.Tokenize(tt.input)
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("%s: got %v, want *SyntaxError", tt.input, err)
+			continue
+		}
+		if se.Offset != tt.offset {
+			t.Errorf("%s: offset %d, want %d", tt.input, se.Offset, tt.offset)
+		}
+		if len(pairs) != tt.pairs {
+			t.Errorf("%s: got %d pairs before the error, want %d", tt.input, len(pairs), tt.pairs)
+		}
+	}
+}
+
+func TestParser_TokenizeOffsets(t *testing.T) {
+	pairs, err := NewParser("test").Tokenize("a:1;  b ; 'c':2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Pair{
+		{Key: "a", Values: []string{"1"}, Offset: 0},
+		{Key: "b", Offset: 6},
+		{Key: "c", Values: []string{"2"}, Offset: 10},
+	}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Errorf("got %+v, want %+v", pairs, want)
+	}
+}
+
+// splitParse is Parse as it was before quoting support: unquoted tags
+// without backslashes must still parse the same.
+func splitParse(p *Parser, tag string) map[string][]string {
+	result := make(map[string][]string)
+	for part := range strings.SplitSeq(tag, p.pairDelimiter) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, found := strings.Cut(part, p.kvSeparator)
+		key = strings.TrimSpace(key)
+		if !found {
+			result[key] = nil
+			continue
+		}
+		var values []string
+		for v := range strings.SplitSeq(strings.TrimSpace(value), p.valueDelim) {
+			values = append(values, strings.TrimSpace(v))
+		}
+		result[key] = values
+	}
+	return result
+}
+
+func TestParser_ParseUnquotedCompat(t *testing.T) {
+	configs := []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"space pairs", []Option{WithPairDelimiter(" "), WithKVSeparator("=")}},
+		{"space pairs and values", []Option{WithPairDelimiter(" "), WithKVSeparator("="), WithValueDelimiter(" ")}},
+		{"space values", []Option{WithValueDelimiter(" ")}},
+		{"space separator", []Option{WithKVSeparator(" ")}},
+		{"tab pairs", []Option{WithPairDelimiter("\t"), WithKVSeparator("=")}},
+		{"custom", []Option{WithPairDelimiter(","), WithKVSeparator("="), WithValueDelimiter("|")}},
+	}
+	inputs := []string{
+		"",
+		"a",
+		"a:b",
+		"a: b , c ;d",
+		"  a : b ; ; c:",
+		"a= b=2",
+		"a=1  b=2 ",
+		" a b ",
+		"a\tb=1\t\tc= 2 ",
+		"k: a  b ",
+		"k:  ",
+		"k :x y",
+		"a:b:c",
+		"a;;b",
+		":x",
+		"a,b=c|d , e",
+		"a=b|c |d, e=  f |",
+		"x y z",
+	}
+	for _, c := range configs {
+		p := NewParser("test", c.opts...)
+		for _, in := range inputs {
+			want := splitParse(p, in)
+			if got := p.Parse(in); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: Parse(%q) = %q, want %q", c.name, in, got, want)
+			}
+		}
+	}
+}